@@ -4,10 +4,11 @@ go 1.15
 
 require (
 	github.com/Masterminds/semver v1.5.0
-	github.com/docker/cli v0.0.0-20191017083524-a8ff7f821017
-	github.com/google/go-containerregistry v0.1.4
-	github.com/sirupsen/logrus v1.7.0
-	github.com/spf13/cobra v1.1.1
+	github.com/docker/cli v27.1.1+incompatible
+	github.com/fsnotify/fsnotify v1.4.9 // indirect
+	github.com/google/go-containerregistry v0.20.2
+	github.com/sirupsen/logrus v1.9.1
+	github.com/spf13/cobra v1.7.0
 	github.com/spf13/viper v1.7.1
-	gopkg.in/yaml.v2 v2.3.0
+	gopkg.in/yaml.v2 v2.4.0
 )
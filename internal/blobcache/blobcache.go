@@ -0,0 +1,282 @@
+// Package blobcache is a content-addressed directory of compressed layers,
+// implementing go-containerregistry's cache.Cache with a size cap and LRU
+// eviction.
+package blobcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tempPrefix = ".tmp-"
+	// mediaTypeSuffix names the file next to each blob holding its media
+	// type.
+	mediaTypeSuffix = ".mediatype"
+)
+
+// Cache stores compressed layers in a directory, one file per digest.
+// Files are only added once fully read and verified. The least recently
+// used files are evicted when the cache grows over maxSize.
+type Cache struct {
+	dir string
+	// maxSize is in bytes, 0 for no limit.
+	maxSize int64
+	mu      sync.Mutex
+}
+
+var _ cache.Cache = &Cache{}
+
+// New returns the cache stored in dir, created if needed.
+func New(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("blob cache : %w", err)
+	}
+	return &Cache{dir: dir, maxSize: maxSize}, nil
+}
+
+func (c *Cache) path(h v1.Hash) string {
+	return filepath.Join(c.dir, h.Algorithm+"-"+h.Hex)
+}
+
+// Get returns a cached layer, or cache.ErrNotFound.
+func (c *Cache) Get(h v1.Hash) (v1.Layer, error) {
+	path := c.path(h)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, cache.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("blob cache : %w", err)
+	}
+
+	// The modification time records the last use.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	// Blobs cached without their media type are Docker layers.
+	mediaType := types.DockerLayer
+	if contents, err := ioutil.ReadFile(path + mediaTypeSuffix); err == nil {
+		mediaType = types.MediaType(contents)
+	}
+	return &cachedLayer{path: path, digest: h, size: info.Size(), mediaType: mediaType}, nil
+}
+
+// Put returns a layer storing its compressed content in the cache once
+// completely read.
+func (c *Cache) Put(l v1.Layer) (v1.Layer, error) {
+	digest, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+	return &cachingLayer{Layer: l, cache: c, digest: digest}, nil
+}
+
+// Delete removes a layer from the cache.
+func (c *Cache) Delete(h v1.Hash) error {
+	os.Remove(c.path(h) + mediaTypeSuffix)
+	err := os.Remove(c.path(h))
+	if os.IsNotExist(err) {
+		return cache.ErrNotFound
+	}
+	return err
+}
+
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *Cache) entries() ([]entry, error) {
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, fmt.Errorf("blob cache : %w", err)
+	}
+	entries := []entry{}
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), tempPrefix) || strings.HasSuffix(info.Name(), mediaTypeSuffix) {
+			continue
+		}
+		entries = append(entries, entry{
+			path:    filepath.Join(c.dir, info.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
+
+// Usage returns the number of cached layers and their total size.
+func (c *Cache) Usage() (int, int64, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := int64(0)
+	for _, e := range entries {
+		size += e.size
+	}
+	return len(entries), size, nil
+}
+
+// Prune evicts the least recently used layers until the cache is at most
+// maxSize bytes. It returns the number of evicted layers and their size.
+func (c *Cache) Prune(maxSize int64) (int, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.entries()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := int64(0)
+	for _, e := range entries {
+		size += e.size
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	evicted, freed := 0, int64(0)
+	for _, e := range entries {
+		if size <= maxSize {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return evicted, freed, fmt.Errorf("blob cache : %w", err)
+		}
+		os.Remove(e.path + mediaTypeSuffix)
+		size -= e.size
+		evicted++
+		freed += e.size
+	}
+	return evicted, freed, nil
+}
+
+// store moves a verified temporary file into the cache next to its media
+// type, then applies the size cap.
+func (c *Cache) store(tmp string, h v1.Hash, mediaType types.MediaType) error {
+	if err := ioutil.WriteFile(c.path(h)+mediaTypeSuffix, []byte(mediaType), 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, c.path(h)); err != nil {
+		os.Remove(tmp)
+		os.Remove(c.path(h) + mediaTypeSuffix)
+		return err
+	}
+	if c.maxSize > 0 {
+		if _, _, err := c.Prune(c.maxSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedLayer is a compressed layer read from the cache. Only what is
+// needed to push it is known.
+type cachedLayer struct {
+	path      string
+	digest    v1.Hash
+	size      int64
+	mediaType types.MediaType
+}
+
+func (l *cachedLayer) Digest() (v1.Hash, error) { return l.digest, nil }
+func (l *cachedLayer) Size() (int64, error)     { return l.size, nil }
+func (l *cachedLayer) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+func (l *cachedLayer) DiffID() (v1.Hash, error) {
+	return v1.Hash{}, fmt.Errorf("blob cache : diff id of %s unknown", l.digest)
+}
+func (l *cachedLayer) Uncompressed() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("blob cache : %s is only cached compressed", l.digest)
+}
+func (l *cachedLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// cachingLayer copies the compressed content of a layer to the cache while
+// it is read.
+type cachingLayer struct {
+	v1.Layer
+	cache  *Cache
+	digest v1.Hash
+}
+
+func (l *cachingLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	if l.digest.Algorithm != "sha256" {
+		return rc, nil
+	}
+	tmp, err := ioutil.TempFile(l.cache.dir, tempPrefix)
+	if err != nil {
+		return rc, nil
+	}
+	return &cachingReader{rc: rc, tmp: tmp, hash: sha256.New(), layer: l}, nil
+}
+
+type cachingReader struct {
+	rc    io.ReadCloser
+	tmp   *os.File
+	hash  hash.Hash
+	layer *cachingLayer
+	eof   bool
+	err   error
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if n > 0 && r.err == nil {
+		r.hash.Write(p[:n])
+		_, r.err = r.tmp.Write(p[:n])
+	}
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// Close keeps the layer in the cache only if it was completely read and
+// matches its digest.
+func (r *cachingReader) Close() error {
+	err := r.rc.Close()
+	tmpErr := r.tmp.Close()
+
+	complete := r.eof && r.err == nil && tmpErr == nil &&
+		hex.EncodeToString(r.hash.Sum(nil)) == r.layer.digest.Hex
+	if !complete {
+		os.Remove(r.tmp.Name())
+		return err
+	}
+	mediaType, mtErr := r.layer.MediaType()
+	if mtErr != nil {
+		os.Remove(r.tmp.Name())
+		log.Warnf("blob cache : not caching %s : %s", r.layer.digest, mtErr)
+		return err
+	}
+	if storeErr := r.layer.cache.store(r.tmp.Name(), r.layer.digest, mediaType); storeErr != nil {
+		log.Warnf("blob cache : not caching %s : %s", r.layer.digest, storeErr)
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package blobcache
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func readCompressed(t *testing.T, l v1.Layer, all bool) []byte {
+	t.Helper()
+	rc, err := l.Compressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []byte
+	if all {
+		contents, err = ioutil.ReadAll(rc)
+	} else {
+		contents = make([]byte, 10)
+		_, err = rc.Read(contents)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestPutAndGet(t *testing.T) {
+	c, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := random.Layer(1024, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, _ := layer.Digest()
+
+	cached, err := c.Put(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readCompressed(t, cached, false)
+	if _, err := c.Get(digest); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("partially read layer must not be cached, got %v", err)
+	}
+
+	want := readCompressed(t, cached, true)
+	got, err := c.Get(digest)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if contents := readCompressed(t, got, true); string(contents) != string(want) {
+		t.Errorf("cached content differs from the layer")
+	}
+	if count, size, _ := c.Usage(); count != 1 || size != int64(len(want)) {
+		t.Errorf("got %d layers of %d bytes, want 1 of %d", count, size, len(want))
+	}
+}
+
+func TestMediaType(t *testing.T) {
+	c, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := random.Layer(1024, types.OCILayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, _ := layer.Digest()
+	cached, err := c.Put(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readCompressed(t, cached, true)
+
+	got, err := c.Get(digest)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if mediaType, _ := got.MediaType(); mediaType != types.OCILayer {
+		t.Errorf("got media type %s, want %s", mediaType, types.OCILayer)
+	}
+	if count, _, _ := c.Usage(); count != 1 {
+		t.Errorf("got %d layers, want 1", count)
+	}
+}
+
+func TestPruneLeastRecentlyUsed(t *testing.T) {
+	c, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digests := []v1.Hash{}
+	sizes := []int64{}
+	total := int64(0)
+	for i := 0; i < 3; i++ {
+		layer, err := random.Layer(1024, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cached, err := c.Put(layer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readCompressed(t, cached, true)
+		digest, _ := layer.Digest()
+		digests = append(digests, digest)
+
+		info, err := os.Stat(c.path(digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, info.Size())
+		total += info.Size()
+
+		old := time.Now().Add(time.Duration(i-10) * time.Hour)
+		if err := os.Chtimes(c.path(digest), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Using the oldest layer makes the second one the least recently used.
+	if _, err := c.Get(digests[0]); err != nil {
+		t.Fatal(err)
+	}
+	evicted, _, err := c.Prune(total - sizes[1])
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if evicted != 1 {
+		t.Errorf("got %d layers evicted, want 1", evicted)
+	}
+	if _, err := c.Get(digests[1]); !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("least recently used layer must be evicted")
+	}
+	for _, digest := range []v1.Hash{digests[0], digests[2]} {
+		if _, err := c.Get(digest); err != nil {
+			t.Errorf("layer %s must be kept, got %v", digest, err)
+		}
+	}
+}
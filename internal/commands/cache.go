@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/barthv/imgsync/internal/blobcache"
+	"github.com/barthv/imgsync/internal/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newCacheCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "cache",
+		Short: "manage the local layer cache",
+	}
+
+	prune := cobra.Command{
+		Use:   "prune",
+		Short: "evict least recently used layers down to cacheMaxSize",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := runCachePruneCommand(); err != nil {
+				return fmt.Errorf("cache prune command: %w", err)
+			}
+
+			return nil
+		},
+	}
+	prune.Flags().Bool("all", false, "Remove every cached layer")
+	viper.BindPFlag("pruneAll", prune.Flags().Lookup("all"))
+
+	cmd.AddCommand(&prune)
+	return &cmd
+}
+
+// openCache returns the layer cache of the configuration, nil when
+// cacheDir is not set.
+func openCache(conf config.Config) (*blobcache.Cache, int64, error) {
+	if conf.CacheDir == "" {
+		return nil, 0, nil
+	}
+	maxSize, err := config.ParseSize(conf.CacheMaxSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	c, err := blobcache.New(conf.CacheDir, maxSize)
+	return c, maxSize, err
+}
+
+func runCachePruneCommand() error {
+	conf, err := config.Get(viper.GetString("confpath"))
+	if err != nil {
+		return err
+	}
+
+	c, maxSize, err := openCache(conf)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return fmt.Errorf("cache prune requires a cacheDir")
+	}
+	if viper.GetBool("pruneAll") {
+		maxSize = 0
+	} else if maxSize == 0 {
+		log.Infof("No cacheMaxSize set, nothing to prune")
+		return nil
+	}
+
+	evicted, freed, err := c.Prune(maxSize)
+	if err != nil {
+		return err
+	}
+	count, size, err := c.Usage()
+	if err != nil {
+		return err
+	}
+	log.Infof("%d layers (%s) evicted, %d layers (%s) left in %s", evicted, formatSize(freed), count, formatSize(size), conf.CacheDir)
+	return nil
+}
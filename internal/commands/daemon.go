@@ -0,0 +1,274 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/barthv/imgsync/internal/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// configPollInterval is how often the daemon checks the config file
+	// for changes. Polling also catches ConfigMap symlink swaps.
+	configPollInterval = 10 * time.Second
+	// maxSyncRequests is how many HTTP sync requests may wait for the
+	// running sync.
+	maxSyncRequests = 64
+)
+
+func newDaemonCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "daemon",
+		Short: "sync images from sources to target on a schedule, and on demand over HTTP",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := runDaemonCommand(); err != nil {
+				return fmt.Errorf("daemon command: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("listen", "", "Address of the HTTP API and webhook receiver, e.g. :8080 (disabled when empty)")
+	viper.BindPFlag("daemonListen", cmd.Flags().Lookup("listen"))
+	cmd.Flags().String("webhook-token", "", "Token required by POST endpoints, as a bearer token or token query parameter (required with --listen)")
+	viper.BindPFlag("webhookToken", cmd.Flags().Lookup("webhook-token"))
+
+	return &cmd
+}
+
+// daemonJob syncs a group of sources sharing a schedule.
+type daemonJob struct {
+	name     string
+	conf     config.Config
+	schedule config.Schedule
+	next     time.Time
+}
+
+// syncRequest is a sync asked over HTTP, of a config and possibly a
+// single tag.
+type syncRequest struct {
+	name string
+	conf config.Config
+	tag  string
+}
+
+// daemon runs sync jobs and requests one at a time, so runs never overlap.
+type daemon struct {
+	confpath string
+	contents []byte
+	jobs     []*daemonJob
+	requests chan syncRequest
+
+	// mu guards the fields read by HTTP handlers.
+	mu    sync.Mutex
+	conf  config.Config
+	ready bool
+}
+
+// newDaemonJobs groups sources by schedule: sources with their own
+// schedule each have a job, the others share the global schedule.
+func newDaemonJobs(conf config.Config, now time.Time) ([]*daemonJob, error) {
+	shared := conf
+	shared.Sources = []config.Source{}
+	jobs := []*daemonJob{}
+	for _, source := range conf.Sources {
+		if !source.Schedule.IsSet() {
+			shared.Sources = append(shared.Sources, source)
+			continue
+		}
+		job := conf
+		job.Sources = []config.Source{source}
+		name := source.Source.GetRepositoryAddress()
+		if source.Source.RepositoryRegex != "" {
+			name = source.Source.RepositoryRegex
+		}
+		jobs = append(jobs, &daemonJob{name: name, conf: job, schedule: *source.Schedule})
+	}
+	if len(shared.Sources) > 0 {
+		jobs = append([]*daemonJob{{name: "sources", conf: shared, schedule: conf.Schedule}}, jobs...)
+	}
+
+	for _, job := range jobs {
+		var err error
+		job.next, err = job.schedule.Next(now)
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", job.name, err)
+		}
+	}
+	return jobs, nil
+}
+
+// load reads the config file, and replaces the jobs when it changed.
+// Jobs run right away the first time. On reload, new or rescheduled jobs
+// are scheduled from now.
+func (d *daemon) load() error {
+	contents, err := ioutil.ReadFile(config.GetConfigLocation(d.confpath))
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	if d.jobs != nil && bytes.Equal(contents, d.contents) {
+		return nil
+	}
+	// An invalid change is reported once.
+	d.contents = contents
+	conf, err := config.Get(d.confpath)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	jobs, err := newDaemonJobs(conf, now)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("no source to sync")
+	}
+	first := d.jobs == nil
+	if first {
+		for _, job := range jobs {
+			job.next = now
+		}
+	} else {
+		log.Infoln("Config reloaded")
+		keepSchedules(jobs, d.jobs)
+	}
+	d.jobs = jobs
+	d.mu.Lock()
+	d.conf, d.ready = conf, true
+	d.mu.Unlock()
+	for _, job := range d.jobs {
+		log.Infof("%s : next sync at %s", job.name, job.next.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// keepSchedules keeps the next run of reloaded jobs whose schedule did not
+// change, so that config edits do not postpone them.
+func keepSchedules(jobs []*daemonJob, previous []*daemonJob) {
+	next := map[string]*daemonJob{}
+	for _, job := range previous {
+		next[job.name] = job
+	}
+	for _, job := range jobs {
+		if old, ok := next[job.name]; ok && old.schedule == job.schedule {
+			job.next = old.next
+		}
+	}
+}
+
+// nextJob returns the job due first.
+func (d *daemon) nextJob() *daemonJob {
+	next := d.jobs[0]
+	for _, job := range d.jobs[1:] {
+		if job.next.Before(next.next) {
+			next = job
+		}
+	}
+	return next
+}
+
+// run syncs a job, then schedules its next run.
+func (d *daemon) run(ctx context.Context, job *daemonJob) {
+	log.Infof("%s : scheduled sync starting", job.name)
+	if err := runSync(ctx, job.conf, ""); err != nil {
+		log.Errorf("%s : scheduled sync failed : %s", job.name, err)
+	}
+
+	// Schedules are validated when loaded.
+	job.next, _ = job.schedule.Next(time.Now())
+	if ctx.Err() == nil {
+		log.Infof("%s : next sync at %s", job.name, job.next.Format(time.RFC3339))
+	}
+}
+
+// runRequest syncs a request received over HTTP.
+func (d *daemon) runRequest(ctx context.Context, request syncRequest) {
+	log.Infof("%s : requested sync starting", request.name)
+	if err := runSync(ctx, request.conf, request.tag); err != nil {
+		log.Errorf("%s : requested sync failed : %s", request.name, err)
+	}
+}
+
+func runDaemonCommand() error {
+	listen, token := viper.GetString("daemonListen"), viper.GetString("webhookToken")
+	if listen != "" && token == "" {
+		return fmt.Errorf("--listen requires --webhook-token, anyone reaching %s could trigger syncs", listen)
+	}
+
+	d := &daemon{
+		confpath: viper.GetString("confpath"),
+		requests: make(chan syncRequest, maxSyncRequests),
+	}
+	if err := d.load(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Infof("%s received, stopping once in-flight copies are done", sig)
+		cancel()
+		<-signals
+		log.Warnln("Second signal received, exiting now")
+		os.Exit(1)
+	}()
+
+	if listen != "" {
+		server := &http.Server{Addr: listen, Handler: d.handler(token)}
+		go func() {
+			log.Infof("Listening on %s", listen)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("http server : %s", err)
+				cancel()
+			}
+		}()
+		defer func() {
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer shutdownCancel()
+			server.Shutdown(shutdownCtx)
+		}()
+	}
+
+	poll := time.NewTicker(configPollInterval)
+	defer poll.Stop()
+	for {
+		job := d.nextJob()
+		timer := time.NewTimer(time.Until(job.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			d.mu.Lock()
+			d.ready = false
+			d.mu.Unlock()
+			log.Infoln("Daemon stopped")
+			return nil
+		case request := <-d.requests:
+			timer.Stop()
+			d.runRequest(ctx, request)
+		case <-poll.C:
+			timer.Stop()
+			if err := d.load(); err != nil {
+				log.Errorf("config reload failed, keeping the previous config : %s", err)
+			}
+		case <-timer.C:
+			d.run(ctx, job)
+		}
+	}
+}
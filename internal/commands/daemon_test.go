@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/barthv/imgsync/internal/config"
+)
+
+func TestNewDaemonJobs(t *testing.T) {
+	now := time.Date(2024, time.January, 31, 22, 30, 0, 0, time.UTC)
+	conf := config.Config{
+		Schedule: config.Schedule{Interval: "30m"},
+		Sources: []config.Source{
+			{Source: config.Repo{Repository: "nginx"}},
+			{Source: config.Repo{Host: "quay.io", Repository: "team/app"}, Schedule: &config.Schedule{Cron: "0 3 * * *"}},
+			{Source: config.Repo{Repository: "redis"}},
+		},
+	}
+
+	jobs, err := newDaemonJobs(conf, now)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(jobs))
+	}
+	if len(jobs[0].conf.Sources) != 2 || !jobs[0].next.Equal(now.Add(30*time.Minute)) {
+		t.Errorf("got shared job %+v", jobs[0])
+	}
+	if jobs[1].name != "quay.io/team/app" || len(jobs[1].conf.Sources) != 1 ||
+		!jobs[1].next.Equal(time.Date(2024, time.February, 1, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("got source job %+v", jobs[1])
+	}
+	if len(conf.Sources) != 3 {
+		t.Errorf("got config sources changed")
+	}
+
+	conf.Sources[1].Schedule.Cron = "0 3 * *"
+	if _, err := newDaemonJobs(conf, now); err == nil {
+		t.Errorf("got no error for an invalid source schedule")
+	}
+}
+
+func TestKeepSchedules(t *testing.T) {
+	now := time.Date(2024, time.January, 31, 22, 30, 0, 0, time.UTC)
+	previous := []*daemonJob{
+		{name: "sources", schedule: config.Schedule{Interval: "30m"}, next: now.Add(time.Minute)},
+		{name: "quay.io/team/app", schedule: config.Schedule{Cron: "0 3 * * *"}, next: now.Add(time.Hour)},
+	}
+	jobs := []*daemonJob{
+		{name: "sources", schedule: config.Schedule{Interval: "30m"}, next: now.Add(30 * time.Minute)},
+		{name: "quay.io/team/app", schedule: config.Schedule{Cron: "0 4 * * *"}, next: now.Add(2 * time.Hour)},
+		{name: "redis", schedule: config.Schedule{Interval: "1h"}, next: now.Add(time.Hour)},
+	}
+
+	keepSchedules(jobs, previous)
+	want := []time.Time{now.Add(time.Minute), now.Add(2 * time.Hour), now.Add(time.Hour)}
+	for i, job := range jobs {
+		if !job.next.Equal(want[i]) {
+			t.Errorf("got %s next at %s, want %s", job.name, job.next, want[i])
+		}
+	}
+}
@@ -52,6 +52,14 @@ func NewDefaultCommand() *cobra.Command {
 	viper.AutomaticEnv()
 
 	cmd.AddCommand(newSyncCommand())
+	cmd.AddCommand(newLockCommand())
+	cmd.AddCommand(newStatusCommand())
+	cmd.AddCommand(newCacheCommand())
+	cmd.AddCommand(newExportCommand())
+	cmd.AddCommand(newImportCommand())
+	cmd.AddCommand(newDiscoverCommand())
+	cmd.AddCommand(newRewriteCommand())
+	cmd.AddCommand(newDaemonCommand())
 
 	return &cmd
 }
@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/discover"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newDiscoverCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "discover [path...]",
+		Short: "add the images referenced by Kubernetes manifests, Compose files and Dockerfiles to the config sources",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				args = []string{"."}
+			}
+			if err := runDiscoverCommand(args); err != nil {
+				return fmt.Errorf("discover command: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("write", false, "Write the merged config in place instead of printing it, comments are not kept")
+	viper.BindPFlag("discoverWrite", cmd.Flags().Lookup("write"))
+
+	return &cmd
+}
+
+func runDiscoverCommand(paths []string) error {
+	images, failed, err := discover.Walk(paths)
+	if err != nil {
+		return err
+	}
+	for _, err := range failed {
+		log.Warnf("%s, skipped", err)
+	}
+	sources, skipped := discover.Sources(images)
+	for _, image := range skipped {
+		log.Warnf("%s : %s cannot be synced by tag, skipped", image.File, image.Reference)
+	}
+	log.Infof("%d images of %d repositories discovered", len(images)-len(skipped), len(sources))
+
+	configLocation := config.GetConfigLocation(viper.GetString("confpath"))
+	contents, err := ioutil.ReadFile(configLocation)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	merged, added, err := config.MergeSources(contents, sources)
+	if err != nil {
+		return err
+	}
+
+	if !viper.GetBool("discoverWrite") {
+		fmt.Print(string(merged))
+		return nil
+	}
+	if added == 0 {
+		log.Infof("%s already syncs every discovered tag", configLocation)
+		return nil
+	}
+	if err := ioutil.WriteFile(configLocation, merged, 0644); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	log.Infof("%d tags added to %s", added, configLocation)
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/repo"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newExportCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "export",
+		Short: "export selected images to an OCI image layout or archive",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := runExportCommand(); err != nil {
+				return fmt.Errorf("export command: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "", "OCI image layout directory, or tar archive when ending with .tar")
+	viper.BindPFlag("exportOutput", cmd.Flags().Lookup("output"))
+	cmd.Flags().String("since", "", "Previous export whose blobs are left out of this one")
+	viper.BindPFlag("exportSince", cmd.Flags().Lookup("since"))
+
+	return &cmd
+}
+
+func runExportCommand() error {
+	output := viper.GetString("exportOutput")
+	if output == "" {
+		return fmt.Errorf("an output is required")
+	}
+
+	conf, err := config.Get(viper.GetString("confpath"))
+	if err != nil {
+		return err
+	}
+
+	retry, err := newRetryPolicy(context.Background(), conf.Retry)
+	if err != nil {
+		return err
+	}
+
+	skip := map[v1.Hash]bool{}
+	if since := viper.GetString("exportSince"); since != "" {
+		skip, err = repo.LayoutBlobs(since)
+		if err != nil {
+			return err
+		}
+		log.Infof("Leaving out %d blobs of previous export %s", len(skip), since)
+	}
+
+	dir := output
+	archive := strings.HasSuffix(output, ".tar")
+	if archive {
+		dir, err = ioutil.TempDir(filepath.Dir(output), ".imgsync-export-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	exported := 0
+	written := int64(0)
+	sources, err := expandSources(conf, retry)
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		sourceRepoAddr := source.Source.GetRepositoryAddress()
+
+		if err := setHostLimits(source.Source); err != nil {
+			return err
+		}
+		if err := setSource(source.Source); err != nil {
+			return err
+		}
+
+		if source.Source.Auth.Username != "" {
+			log.Debugf("%s : encoding source credentials", sourceRepoAddr)
+			err := repo.SetHostCredentials(sourceRepoAddr, source.Source.Auth.Username, source.Source.Auth.Password)
+			if err != nil {
+				log.Errorf("source auth failed : %s", err)
+				return err
+			}
+		}
+
+		var sourceRepoTags []string
+		_, err := retry.do(sourceRepoAddr, func() error {
+			var err error
+			sourceRepoTags, err = repo.ListRepo(sourceRepoAddr)
+			return err
+		})
+		if conf.ContinueOnSyncError && err != nil {
+			log.Debugf("%s", err)
+			log.Warnln("continueOnSyncError flag enabled : List source error ignored.")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		sourceFilteredTags, err := source.FilterTags(sourceRepoTags)
+		if err != nil {
+			return err
+		}
+		for _, tag := range source.MutableTags {
+			if stringInSlice(tag, sourceRepoTags) && !stringInSlice(tag, sourceFilteredTags) {
+				sourceFilteredTags = append(sourceFilteredTags, tag)
+			}
+		}
+		sort.Strings(sourceFilteredTags)
+		log.Infof("%s : %d/%d tags matching selectors", sourceRepoAddr, len(sourceFilteredTags), len(sourceRepoTags))
+
+		targetRepoAddr := source.GetTargetRepositoryAddress(conf.Target)
+		pins := source.TagPins()
+		for _, tag := range sourceFilteredTags {
+			log.Infof("%s : exporting %s", sourceRepoAddr, tag)
+			var size int64
+			_, err := retry.do(sourceRepoAddr+":"+tag, func() error {
+				var err error
+				size, err = repo.ExportTag(tag, pins[tag], sourceRepoAddr, targetRepoAddr, dir, source.Platforms, skip)
+				return err
+			})
+			if errors.Is(err, repo.ErrNoMatchingPlatform) {
+				log.Warnf("%s : skipping %s, no image matching platforms %v", sourceRepoAddr, tag, source.Platforms)
+				continue
+			}
+			if conf.ContinueOnSyncError && err != nil {
+				log.Errorf("%s", err)
+				log.Warnln("continueOnSyncError flag enabled : Export error ignored.")
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			exported++
+			written += size
+		}
+	}
+
+	if archive {
+		if err := repo.ArchiveLayout(dir, output); err != nil {
+			return err
+		}
+	}
+	log.Infof("%d tags (%s) exported to %s", exported, formatSize(written), output)
+
+	return nil
+}
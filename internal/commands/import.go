@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/repo"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newImportCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "import",
+		Short: "import an exported OCI image layout or archive to target",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := runImportCommand(); err != nil {
+				return fmt.Errorf("import command: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("input", "i", "", "OCI image layout directory or tar archive written by export")
+	viper.BindPFlag("importInput", cmd.Flags().Lookup("input"))
+
+	return &cmd
+}
+
+// importTarget returns the target of an exported tag: the target of the
+// configured source it was exported from, pattern sources included, or
+// the target recorded at export.
+func importTarget(conf config.Config, tag repo.LayoutTag) (string, bool) {
+	if source, ok, err := conf.MatchSource(tag.Source); err == nil && ok {
+		return source.GetTargetRepositoryAddress(conf.Target), true
+	}
+	return tag.Target, tag.Target != ""
+}
+
+func runImportCommand() error {
+	input := viper.GetString("importInput")
+	if input == "" {
+		return fmt.Errorf("an input is required")
+	}
+
+	conf, err := config.Get(viper.GetString("confpath"))
+	if err != nil {
+		return err
+	}
+
+	retry, err := newRetryPolicy(context.Background(), conf.Retry)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(input)
+	if err != nil {
+		return err
+	}
+	dir := input
+	if !info.IsDir() {
+		dir, err = ioutil.TempDir(filepath.Dir(input), ".imgsync-import-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		log.Infof("Extracting %s", input)
+		if err := repo.ExtractLayout(input, dir); err != nil {
+			return err
+		}
+	}
+
+	verified, err := repo.VerifyLayout(dir)
+	if err != nil {
+		return err
+	}
+	log.Infof("%d blobs verified in %s", verified, input)
+
+	tags, err := repo.LayoutTags(dir)
+	if err != nil {
+		return err
+	}
+
+	targetAddr := conf.Target.GetRepositoryAddress()
+	if err := setHostLimits(conf.Target); err != nil {
+		return err
+	}
+	if conf.Target.Auth.Username != "" {
+		log.Debugln("Encoding target credentials")
+		err := repo.SetHostCredentials(targetAddr, conf.Target.Auth.Username, conf.Target.Auth.Password)
+		if err != nil {
+			log.Errorf("target auth failed : %s", err)
+			return err
+		}
+	}
+
+	dest, err := newDestination(conf.Target)
+	if err != nil {
+		return err
+	}
+	repo.SetDestination(dest)
+	printer := newProgressPrinter(os.Stdout)
+	repo.SetProgressHandler(printer.update)
+	defer printer.routeLogs()()
+
+	imported := 0
+	skipped := 0
+	for _, tag := range tags {
+		targetRepoAddr, ok := importTarget(conf, tag)
+		if !ok {
+			log.Warnf("%s : no target for %s, skipped", tag.Source, tag.Tag)
+			skipped++
+			continue
+		}
+
+		digest, err := dest.TagDigest(tag.Tag, targetRepoAddr)
+		if err == nil && digest == tag.Digest.String() {
+			log.Infof("%s : %s already in %s, skipped", tag.Source, tag.Tag, targetRepoAddr)
+			skipped++
+			continue
+		}
+
+		log.Infof("%s : importing %s to %s:%s", tag.Source, tag.Tag, targetRepoAddr, tag.Tag)
+		_, err = retry.do(targetRepoAddr+":"+tag.Tag, func() error {
+			return repo.ImportTag(dir, tag.Digest, tag.Tag, targetRepoAddr)
+		})
+		if conf.ContinueOnSyncError && err != nil {
+			log.Errorf("%s", err)
+			log.Warnln("continueOnSyncError flag enabled : Import error ignored.")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		imported++
+	}
+	log.Infof("%d tags imported, %d skipped", imported, skipped)
+
+	return nil
+}
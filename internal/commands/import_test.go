@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/repo"
+)
+
+func TestImportTarget(t *testing.T) {
+	conf := config.Config{
+		Target: config.Repo{Host: "mirror.local"},
+		Sources: []config.Source{
+			{Source: config.Repo{Host: "quay.io", Repository: "app/web"}},
+			{Source: config.Repo{Repository: "bitnami/*"}},
+		},
+	}
+
+	var tests = []struct {
+		name   string
+		tag    repo.LayoutTag
+		want   string
+		wantOk bool
+	}{
+		{"configured source", repo.LayoutTag{Source: "quay.io/app/web", Target: "old.local/app/web"}, "mirror.local/app/web", true},
+		{"pattern source", repo.LayoutTag{Source: "index.docker.io/bitnami/redis", Target: "old.local/redis"}, "mirror.local/bitnami/redis", true},
+		{"recorded target", repo.LayoutTag{Source: "quay.io/app/api", Target: "old.local/app/api"}, "old.local/app/api", true},
+		{"unknown", repo.LayoutTag{Source: "quay.io/app/api"}, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := importTarget(conf, test.tag)
+			if got != test.want || ok != test.wantOk {
+				t.Errorf("got %s, %v, want %s, %v", got, ok, test.want, test.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/repo"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newLockCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "lock",
+		Short: "resolve selected tags to digests and write the lock file",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := runLockCommand(); err != nil {
+				return fmt.Errorf("lock command: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	return &cmd
+}
+
+func runLockCommand() error {
+	conf, err := config.Get(viper.GetString("confpath"))
+	if err != nil {
+		return err
+	}
+
+	retry, err := newRetryPolicy(context.Background(), conf.Retry)
+	if err != nil {
+		return err
+	}
+	sources, err := expandSources(conf, retry)
+	if err != nil {
+		return err
+	}
+
+	lock := config.Lock{}
+	for _, source := range sources {
+		sourceRepoAddr := source.Source.GetRepositoryAddress()
+
+		if err := setSource(source.Source); err != nil {
+			return err
+		}
+
+		if source.Source.Auth.Username != "" {
+			log.Debugf("%s : encoding source credentials", sourceRepoAddr)
+			err := repo.SetHostCredentials(sourceRepoAddr, source.Source.Auth.Username, source.Source.Auth.Password)
+			if err != nil {
+				log.Errorf("source auth failed : %s", err)
+				return err
+			}
+		}
+
+		var sourceRepoTags []string
+		_, err := retry.do(sourceRepoAddr, func() error {
+			var err error
+			sourceRepoTags, err = repo.ListRepo(sourceRepoAddr)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		sourceFilteredTags, err := source.FilterTags(sourceRepoTags)
+		if err != nil {
+			return err
+		}
+		for _, tag := range source.MutableTags {
+			if stringInSlice(tag, sourceRepoTags) && !stringInSlice(tag, sourceFilteredTags) {
+				sourceFilteredTags = append(sourceFilteredTags, tag)
+			}
+		}
+		sort.Strings(sourceFilteredTags)
+
+		pins := source.TagPins()
+		lockedTags := map[string]string{}
+		for _, tag := range sourceFilteredTags {
+			var digest string
+			_, err := retry.do(sourceRepoAddr+":"+tag, func() error {
+				var err error
+				digest, err = repo.TagDigest(tag, sourceRepoAddr)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			// A lock file must not record content that sync would refuse.
+			if pinned, ok := pins[tag]; ok && pinned != digest {
+				return fmt.Errorf("%s : %s upstream digest %s does not match pinned digest %s", sourceRepoAddr, tag, digest, pinned)
+			}
+			lockedTags[tag] = digest
+		}
+
+		log.Infof("%s : %d tags locked", sourceRepoAddr, len(lockedTags))
+		lock.SetLockedTags(sourceRepoAddr, lockedTags)
+	}
+
+	lockLocation := config.GetLockLocation(viper.GetString("confpath"))
+	if err := lock.Save(lockLocation); err != nil {
+		return err
+	}
+	log.Infof("Lock file written to %s", lockLocation)
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/barthv/imgsync/internal/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	progressRedrawInterval = 100 * time.Millisecond
+	progressLogInterval    = 10 * time.Second
+)
+
+// transfer is the progress of an image pushed to the target.
+type transfer struct {
+	ref      string
+	start    time.Time
+	total    int64
+	complete int64
+	// layers and layersDone count the layers of the pushed manifests.
+	layers     int
+	layersDone int
+	// logged is when a progress line was last printed, in log mode.
+	logged time.Time
+	done   bool
+	err    error
+}
+
+func (t *transfer) String() string {
+	line := fmt.Sprintf("%s : %s/%s", t.ref, formatSize(t.complete), formatSize(t.total))
+	if t.layers > 0 {
+		line += fmt.Sprintf(" layers %d/%d", t.layersDone, t.layers)
+	}
+	if t.total > 0 {
+		line += fmt.Sprintf(" %3d%%", t.complete*100/t.total)
+	}
+
+	if t.err != nil {
+		return line + " failed"
+	}
+	elapsed := time.Since(t.start)
+	if elapsed < time.Second || t.complete == 0 {
+		return line
+	}
+	throughput := float64(t.complete) / elapsed.Seconds()
+	line += fmt.Sprintf(" %s/s", formatSize(int64(throughput)))
+	if !t.done {
+		eta := time.Duration(float64(t.total-t.complete) / throughput * float64(time.Second))
+		line += " ETA " + formatAge(eta)
+	} else {
+		line += " in " + formatAge(elapsed)
+	}
+	return line
+}
+
+// progressPrinter renders transfer progress as a live multi-line display
+// on a terminal, and as periodic log lines otherwise.
+type progressPrinter struct {
+	mu   sync.Mutex
+	out  io.Writer
+	live bool
+	// logs is where log lines are written while routed through the
+	// live display.
+	logs      io.Writer
+	transfers map[string]*transfer
+	order     []string
+	// drawn is the number of lines of the live display.
+	drawn  int
+	redraw time.Time
+}
+
+func newProgressPrinter(out *os.File) *progressPrinter {
+	live := false
+	if info, err := out.Stat(); err == nil {
+		live = info.Mode()&os.ModeCharDevice != 0
+	}
+	return &progressPrinter{
+		out:       out,
+		live:      live,
+		transfers: map[string]*transfer{},
+	}
+}
+
+func (p *progressPrinter) update(progress repo.Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.transfers[progress.Ref]
+	if !ok {
+		t = &transfer{ref: progress.Ref, start: time.Now(), logged: time.Now()}
+		p.transfers[progress.Ref] = t
+		p.order = append(p.order, progress.Ref)
+	}
+	t.total = progress.Total
+	t.complete = progress.Complete
+	t.layers = progress.Layers
+	t.layersDone = progress.LayersDone
+	t.done = progress.Done
+	t.err = progress.Err
+
+	if p.live {
+		p.draw(!ok || progress.Done)
+	} else if progress.Done {
+		// Short transfers are not worth a line.
+		if time.Since(t.start) >= progressLogInterval && t.err == nil {
+			log.Infof("%s", t)
+		}
+	} else if time.Since(t.logged) >= progressLogInterval {
+		t.logged = time.Now()
+		log.Infof("%s", t)
+	}
+
+	if progress.Done {
+		p.remove(progress.Ref)
+	}
+}
+
+// draw rewrites the live display: finished transfers are printed once
+// above the lines of transfers in progress.
+func (p *progressPrinter) draw(force bool) {
+	if !force && time.Since(p.redraw) < progressRedrawInterval {
+		return
+	}
+	p.redraw = time.Now()
+
+	if p.drawn > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.drawn)
+	}
+	active := []*transfer{}
+	for _, ref := range p.order {
+		t := p.transfers[ref]
+		if t.done {
+			fmt.Fprintf(p.out, "%s\n", t)
+			continue
+		}
+		active = append(active, t)
+	}
+	for _, t := range active {
+		fmt.Fprintf(p.out, "%s\n", t)
+	}
+	p.drawn = len(active)
+}
+
+// routeLogs writes log lines above the live display instead of over it,
+// until the returned function is called.
+func (p *progressPrinter) routeLogs() func() {
+	if !p.live {
+		return func() {}
+	}
+	logger := log.StandardLogger()
+	p.logs = logger.Out
+	logger.SetOutput(p)
+	return func() {
+		logger.SetOutput(p.logs)
+	}
+}
+
+// Write writes a log line, clearing the live display and drawing it again
+// below the line.
+func (p *progressPrinter) Write(line []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.drawn > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.drawn)
+		p.drawn = 0
+	}
+	n, err := p.logs.Write(line)
+	p.draw(true)
+	return n, err
+}
+
+func (p *progressPrinter) remove(ref string) {
+	delete(p.transfers, ref)
+	for i, r := range p.order {
+		if r == ref {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barthv/imgsync/internal/repo"
+)
+
+func TestTransferString(t *testing.T) {
+	tr := &transfer{ref: "127.0.0.1:5000/nginx:1.19.0", start: time.Now().Add(-10 * time.Second), total: 4 << 20, complete: 1 << 20, layers: 4, layersDone: 1}
+	line := tr.String()
+	for _, want := range []string{"1.0MiB/4.0MiB", "layers 1/4", "25%", "/s", "ETA 3"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("got '%s', want it to contain '%s'", line, want)
+		}
+	}
+
+	tr.complete = tr.total
+	tr.done = true
+	if line := tr.String(); !strings.Contains(line, "100%") || !strings.Contains(line, "in 10s") {
+		t.Errorf("got '%s', want a completed transfer", line)
+	}
+}
+
+func TestProgressPrinterLive(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := &progressPrinter{out: out, live: true, transfers: map[string]*transfer{}}
+
+	p.update(repo.Progress{Ref: "a", Total: 100, Complete: 10})
+	p.update(repo.Progress{Ref: "b", Total: 100, Complete: 10})
+	if p.drawn != 2 {
+		t.Errorf("got %d lines drawn, want 2 transfers in progress", p.drawn)
+	}
+	p.update(repo.Progress{Ref: "a", Total: 100, Complete: 100, Done: true})
+	if p.drawn != 1 || len(p.transfers) != 1 {
+		t.Errorf("got %d lines drawn and %d transfers, want 1 after completion", p.drawn, len(p.transfers))
+	}
+
+	lines := strings.Split(out.String(), "\x1b[")
+	last := lines[len(lines)-1]
+	if !strings.Contains(last, "a : 100B/100B") || !strings.HasSuffix(strings.TrimSpace(last), "10%") {
+		t.Errorf("got last draw '%q', want finished transfer above running one", last)
+	}
+}
+
+func TestProgressPrinterLogs(t *testing.T) {
+	out := &bytes.Buffer{}
+	logs := &bytes.Buffer{}
+	p := &progressPrinter{out: out, logs: logs, live: true, transfers: map[string]*transfer{}}
+
+	p.update(repo.Progress{Ref: "a", Total: 100, Complete: 10})
+	out.Reset()
+	if _, err := p.Write([]byte("level=info msg=synced\n")); err != nil {
+		t.Fatal(err)
+	}
+	if logs.String() != "level=info msg=synced\n" {
+		t.Errorf("got logs '%s'", logs.String())
+	}
+	if got := out.String(); !strings.HasPrefix(got, "\x1b[1A\x1b[J") || !strings.Contains(got, "a : 10B/100B") || p.drawn != 1 {
+		t.Errorf("got display '%q', want it cleared and drawn again", got)
+	}
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/barthv/imgsync/internal/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	statusSynced  = "synced"
+	statusSkipped = "skipped"
+	statusRefused = "refused"
+	statusFailed  = "failed"
+	statusMutated = "mutated"
+)
+
+// reportEntry is the outcome of a single tag during a sync run.
+type reportEntry struct {
+	source  string
+	tag     string
+	status  string
+	message string
+	// attempts is the number of sync attempts made for the tag.
+	attempts int
+}
+
+// syncReport collects tag outcomes of a sync run, summarized at the end.
+type syncReport struct {
+	entries []reportEntry
+	// mountedBlobs and mountedSize are the blob mounts counted before the
+	// run, as repo counts them for the whole process.
+	mountedBlobs int
+	mountedSize  int64
+}
+
+func newSyncReport() *syncReport {
+	mountedBlobs, mountedSize := repo.MountedBlobs()
+	return &syncReport{
+		mountedBlobs: mountedBlobs,
+		mountedSize:  mountedSize,
+	}
+}
+
+func (r *syncReport) add(source string, tag string, status string, message string, attempts int) {
+	r.entries = append(r.entries, reportEntry{
+		source:   source,
+		tag:      tag,
+		status:   status,
+		message:  message,
+		attempts: attempts,
+	})
+}
+
+func (r *syncReport) count(status string) int {
+	count := 0
+	for _, entry := range r.entries {
+		if entry.status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *syncReport) countRetried() int {
+	count := 0
+	for _, entry := range r.entries {
+		if entry.attempts > 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// print logs a summary of the run and details of every tag not synced.
+func (r *syncReport) print() {
+	log.Infof("Sync report : %d synced, %d skipped, %d refused, %d failed, %d mutated, %d retried",
+		r.count(statusSynced), r.count(statusSkipped), r.count(statusRefused), r.count(statusFailed), r.count(statusMutated), r.countRetried())
+	if mountedBlobs, mountedSize := repo.MountedBlobs(); mountedBlobs > r.mountedBlobs {
+		log.Infof("Sync report : %d blobs (%s) mounted from other target repositories instead of uploaded",
+			mountedBlobs-r.mountedBlobs, formatSize(mountedSize-r.mountedSize))
+	}
+
+	for _, entry := range r.entries {
+		attempts := ""
+		if entry.attempts > 1 {
+			attempts = fmt.Sprintf(" (%d attempts)", entry.attempts)
+		}
+		switch entry.status {
+		case statusSynced:
+			if entry.attempts > 1 {
+				log.Infof("%s : %s %s%s", entry.source, entry.tag, entry.status, attempts)
+			}
+		case statusSkipped, statusMutated:
+			log.Warnf("%s : %s %s : %s%s", entry.source, entry.tag, entry.status, entry.message, attempts)
+		default:
+			log.Errorf("%s : %s %s : %s%s", entry.source, entry.tag, entry.status, entry.message, attempts)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+// retryPolicy retries retryable registry errors with an exponential
+// backoff and jitter. Waits end early once ctx is done.
+type retryPolicy struct {
+	ctx          context.Context
+	attempts     int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	sleep        func(context.Context, time.Duration) error
+}
+
+func newRetryPolicy(ctx context.Context, conf config.Retry) (retryPolicy, error) {
+	initialDelay, maxDelay, err := conf.GetDelays()
+	if err != nil {
+		return retryPolicy{}, err
+	}
+	return retryPolicy{
+		ctx:          ctx,
+		attempts:     conf.GetAttempts(),
+		initialDelay: initialDelay,
+		maxDelay:     maxDelay,
+		sleep:        sleep,
+	}, nil
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delay returns the wait before the given retry (starting at 1): the
+// backoff doubles each time up to maxDelay, then half of it is randomized.
+func (p retryPolicy) delay(retry int) time.Duration {
+	backoff := p.initialDelay
+	for i := 1; i < retry && backoff < p.maxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxDelay {
+		backoff = p.maxDelay
+	}
+	if backoff <= 1 {
+		return backoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
+
+// do runs operation until it succeeds, fails with a non retryable error,
+// runs out of attempts or ctx is done. It returns the number of attempts
+// made.
+func (p retryPolicy) do(description string, operation func() error) (int, error) {
+	attempt := 0
+	for {
+		attempt++
+		err := operation()
+		if err == nil {
+			return attempt, nil
+		}
+
+		class := repo.ClassifyError(err)
+		if !class.Retryable() || attempt >= p.attempts {
+			return attempt, err
+		}
+
+		delay := p.delay(attempt)
+		var rateLimitErr *repo.RateLimitError
+		if errors.As(err, &rateLimitErr) && !rateLimitErr.Reset.IsZero() {
+			// Waiting longer than maxDelay is left to the caller.
+			delay = time.Until(rateLimitErr.Reset)
+			if delay > p.maxDelay {
+				return attempt, err
+			}
+		}
+		log.Warnf("%s : %s error, retrying in %s (attempt %d/%d)", description, class, delay.Round(time.Millisecond), attempt+1, p.attempts)
+		log.Debugf("%s", err)
+		if p.sleep(p.ctx, delay) != nil {
+			return attempt, err
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+func TestRetryPolicyDo(t *testing.T) {
+	serverErr := fmt.Errorf("repo copy tag : %w", &transport.Error{StatusCode: http.StatusBadGateway})
+	authErr := fmt.Errorf("repo copy tag : %w", &transport.Error{StatusCode: http.StatusUnauthorized})
+
+	var tests = []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", []error{nil}, 1, nil},
+		{"transient", []error{serverErr, serverErr, nil}, 3, nil},
+		{"exhausted", []error{serverErr, serverErr, serverErr, nil}, 3, serverErr},
+		{"not retryable", []error{authErr, nil}, 1, authErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slept := []time.Duration{}
+			policy := retryPolicy{
+				attempts:     3,
+				initialDelay: time.Second,
+				maxDelay:     time.Minute,
+				ctx:          context.Background(),
+				sleep: func(ctx context.Context, d time.Duration) error {
+					slept = append(slept, d)
+					return nil
+				},
+			}
+
+			calls := 0
+			attempts, err := policy.do("test", func() error {
+				calls++
+				return test.errs[calls-1]
+			})
+			if attempts != test.wantAttempts || calls != test.wantAttempts {
+				t.Errorf("got %d attempts and %d calls, want %d", attempts, calls, test.wantAttempts)
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got error '%v', want '%v'", err, test.wantErr)
+			}
+			if len(slept) != attempts-1 {
+				t.Errorf("got %d waits for %d attempts", len(slept), attempts)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	policy := retryPolicy{ctx: ctx, attempts: 3, initialDelay: time.Hour, maxDelay: time.Hour, sleep: sleep}
+
+	serverErr := &transport.Error{StatusCode: http.StatusBadGateway}
+	attempts, err := policy.do("test", func() error { return serverErr })
+	if attempts != 1 || !errors.Is(err, serverErr) {
+		t.Errorf("got %d attempts, '%v', want 1 attempt and the server error", attempts, err)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := retryPolicy{initialDelay: time.Second, maxDelay: 5 * time.Second}
+
+	var tests = []struct {
+		retry int
+		max   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("retry %d", test.retry), func(t *testing.T) {
+			delay := policy.delay(test.retry)
+			if delay < test.max/2 || delay > test.max {
+				t.Errorf("got %s, want between %s and %s", delay, test.max/2, test.max)
+			}
+		})
+	}
+}
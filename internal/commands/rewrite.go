@@ -0,0 +1,192 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/discover"
+	"github.com/barthv/imgsync/internal/repo"
+	"github.com/barthv/imgsync/internal/rewrite"
+	"github.com/google/go-containerregistry/pkg/name"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newRewriteCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "rewrite path...",
+		Short: "rewrite image references of Kubernetes manifests and Compose files to the target",
+		Args:  cobra.MinimumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := runRewriteCommand(args); err != nil {
+				return fmt.Errorf("rewrite command: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("pin", false, "Pin rewritten references to their target digest")
+	viper.BindPFlag("rewritePin", cmd.Flags().Lookup("pin"))
+	cmd.Flags().Bool("dry-run", false, "Print the rewritten files instead of writing them")
+	viper.BindPFlag("rewriteDryRun", cmd.Flags().Lookup("dry-run"))
+
+	return &cmd
+}
+
+// rewriteReference returns the target reference of an image reference,
+// false when already in the target. References already pinned stay
+// pinned, to their target digest.
+func rewriteReference(conf config.Config, dest repo.Destination, reference string, pin bool) (string, bool, error) {
+	base, digest := reference, ""
+	if i := strings.Index(base, "@"); i >= 0 {
+		base, digest = base[:i], base[i+1:]
+	}
+	ref, err := name.NewTag(base)
+	if err != nil {
+		return "", false, err
+	}
+	if strings.HasPrefix(ref.Context().Name()+"/", strings.TrimSuffix(conf.Target.GetRepositoryAddress(), "/")+"/") {
+		return "", false, nil
+	}
+	if digest != "" && !strings.Contains(base[strings.LastIndex(base, "/")+1:], ":") {
+		return "", false, fmt.Errorf("%s is referenced by digest only", reference)
+	}
+
+	source, ok, err := conf.MatchSource(ref.Context().Name())
+	if err != nil {
+		return "", false, err
+	}
+	if !ok {
+		return "", false, fmt.Errorf("%s is not a source repository", ref.Context().Name())
+	}
+	tag := ref.TagStr()
+	selected, err := source.SelectsTag(tag)
+	if err != nil {
+		return "", false, err
+	}
+	// The latest semver tag is known to be synced once in the target.
+	if !selected && !source.LatestSemverSync {
+		return "", false, fmt.Errorf("%s tag %s is not selected", ref.Context().Name(), tag)
+	}
+
+	entry := rewrite.Entry{Target: source.GetTargetRepositoryAddress(conf.Target), Tag: tag}
+	if pin || digest != "" || !selected {
+		entry.Digest, err = dest.TagDigest(tag, entry.Target)
+		if err != nil {
+			return "", false, fmt.Errorf("%s not found in target : %w", entry.TargetReference(), err)
+		}
+	}
+	return entry.TargetReference(), true, nil
+}
+
+func runRewriteCommand(paths []string) error {
+	conf, err := config.Get(viper.GetString("confpath"))
+	if err != nil {
+		return err
+	}
+
+	if err := setHostLimits(conf.Target); err != nil {
+		return err
+	}
+	if conf.Target.Auth.Username != "" {
+		log.Debugln("Encoding target credentials")
+		err := repo.SetHostCredentials(conf.Target.GetRepositoryAddress(), conf.Target.Auth.Username, conf.Target.Auth.Password)
+		if err != nil {
+			log.Errorf("target auth failed : %s", err)
+			return err
+		}
+	}
+	dest, err := newDestination(conf.Target)
+	if err != nil {
+		return err
+	}
+
+	images, failed, err := discover.Walk(paths)
+	if err != nil {
+		return err
+	}
+	for _, err := range failed {
+		log.Warnf("%s, skipped", err)
+	}
+
+	// Every reference is checked before any file is written.
+	files := []string{}
+	replacements := map[string]map[string]string{}
+	uncovered := 0
+	for _, image := range images {
+		lower := strings.ToLower(image.File)
+		if !strings.HasSuffix(lower, ".yaml") && !strings.HasSuffix(lower, ".yml") {
+			continue
+		}
+		if _, ok := replacements[image.File]; !ok {
+			files = append(files, image.File)
+			replacements[image.File] = map[string]string{}
+		}
+		if _, ok := replacements[image.File][image.Reference]; ok {
+			continue
+		}
+
+		replacement, ok, err := rewriteReference(conf, dest, image.Reference, viper.GetBool("rewritePin"))
+		if err != nil {
+			log.Errorf("%s : %s not covered : %s", image.File, image.Reference, err)
+			uncovered++
+			continue
+		}
+		if ok {
+			replacements[image.File][image.Reference] = replacement
+		}
+	}
+	if uncovered > 0 {
+		return fmt.Errorf("%d image references not covered by the config", uncovered)
+	}
+
+	// Files are only written once every reference is replaced.
+	rewrites := map[string][]byte{}
+	counts := map[string]int{}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		replaced, n, err := rewrite.Replace(contents, replacements[file])
+		if err != nil {
+			return fmt.Errorf("%s : %w", file, err)
+		}
+		if string(replaced) != string(contents) {
+			rewrites[file] = replaced
+			counts[file] = n
+		}
+	}
+
+	rewritten := 0
+	for _, file := range files {
+		replaced, ok := rewrites[file]
+		if viper.GetBool("rewriteDryRun") {
+			if !ok {
+				contents, err := ioutil.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				replaced = contents
+			}
+			fmt.Printf("# %s\n%s", file, replaced)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := ioutil.WriteFile(file, replaced, 0644); err != nil {
+			return err
+		}
+		log.Infof("%s : %d image references rewritten", file, counts[file])
+		rewritten++
+	}
+	log.Infof("%d files rewritten", rewritten)
+
+	return nil
+}
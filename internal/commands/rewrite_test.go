@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// targetDigests is a destination holding tags with known digests.
+type targetDigests map[string]string
+
+func (d targetDigests) ListTags(r string) ([]string, error) { return nil, nil }
+func (d targetDigests) WriteImage(tag string, r string, manifest remote.Taggable) error {
+	return nil
+}
+func (d targetDigests) DeleteTag(tag string, r string) error { return nil }
+func (d targetDigests) TagDigest(tag string, r string) (string, error) {
+	if digest, ok := d[r+":"+tag]; ok {
+		return digest, nil
+	}
+	return "", fmt.Errorf("%s:%s not found", r, tag)
+}
+
+func TestRewriteReference(t *testing.T) {
+	conf := config.Config{
+		Target: config.Repo{Host: "mirror.local", Repository: "hub/"},
+		Sources: []config.Source{
+			{Source: config.Repo{Repository: "nginx"}, Tags: []string{"1.25"}},
+			{Source: config.Repo{Host: "quay.io", Repository: "team/*"}, RegexTags: []string{"^v"}},
+			{Source: config.Repo{Repository: "bitnami/redis"}, LatestSemverSync: true},
+		},
+	}
+	dest := targetDigests{
+		"mirror.local/hub/nginx:1.25":          "sha256:aaaa",
+		"mirror.local/hub/bitnami/redis:7.2.4": "sha256:bbbb",
+	}
+
+	var tests = []struct {
+		reference string
+		pin       bool
+		want      string
+		wantOk    bool
+		wantErr   bool
+	}{
+		{"nginx:1.25", false, "mirror.local/hub/nginx:1.25", true, false},
+		{"docker.io/library/nginx:1.25", true, "mirror.local/hub/nginx:1.25@sha256:aaaa", true, false},
+		{"nginx:1.25@sha256:0000", false, "mirror.local/hub/nginx:1.25@sha256:aaaa", true, false},
+		{"quay.io/team/app:v2", false, "mirror.local/hub/team/app:v2", true, false},
+		{"bitnami/redis:7.2.4", false, "mirror.local/hub/bitnami/redis:7.2.4@sha256:bbbb", true, false},
+		{"mirror.local/hub/nginx:1.25", false, "", false, false},
+		{"mirror.local/hubble/nginx:1.25", false, "", false, true},
+		{"nginx:1.24", false, "", false, true},
+		{"bitnami/redis:7.0.0", false, "", false, true},
+		{"quay.io/other/app:v2", false, "", false, true},
+		{"nginx@sha256:0000", false, "", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.reference, func(t *testing.T) {
+			ans, ok, err := rewriteReference(conf, dest, test.reference, test.pin)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if ans != test.want || ok != test.wantOk {
+				t.Errorf("got '%s' %t, want '%s' %t", ans, ok, test.want, test.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package commands
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/webhook"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxWebhookSize bounds the body of webhook notifications.
+	maxWebhookSize = 1 << 20
+)
+
+// handler serves the daemon HTTP API. POST endpoints require token when set.
+func (d *daemon) handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		ready := d.ready
+		d.mu.Unlock()
+		if !ready {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+	mux.Handle("/sync", authorized(token, http.HandlerFunc(d.serveSync)))
+	mux.Handle("/webhooks/", authorized(token, http.HandlerFunc(d.serveWebhook)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Debugf("http : %s %s from %s", r.Method, redactToken(r.URL), r.RemoteAddr)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// redactToken returns a URL with its token query parameter hidden, for
+// logging.
+func redactToken(u *url.URL) string {
+	query := u.Query()
+	if _, ok := query["token"]; !ok {
+		return u.String()
+	}
+	query.Set("token", "REDACTED")
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// authorized requires POST requests with token, as a bearer token or a
+// query parameter for registries that cannot set headers.
+func authorized(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST required"})
+			return
+		}
+		if token != "" {
+			given := r.URL.Query().Get("token")
+			if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+				given = strings.TrimPrefix(auth, "Bearer ")
+			}
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// sourceRequest returns the request syncing a single source repository,
+// false when no source matches it.
+func (d *daemon) sourceRequest(repository string, tag string) (syncRequest, bool, error) {
+	d.mu.Lock()
+	conf := d.conf
+	d.mu.Unlock()
+
+	source, ok, err := conf.MatchSource(repository)
+	if err != nil || !ok {
+		return syncRequest{}, false, err
+	}
+	conf.Sources = []config.Source{source}
+	name := source.Source.GetRepositoryAddress()
+	if tag != "" {
+		name += ":" + tag
+	}
+	return syncRequest{name: name, conf: conf, tag: tag}, true, nil
+}
+
+// queue adds a sync request, false when too many are waiting.
+func (d *daemon) queue(request syncRequest) bool {
+	select {
+	case d.requests <- request:
+		log.Infof("%s : sync requested", request.name)
+		return true
+	default:
+		log.Warnf("%s : sync request dropped, %d requests already waiting", request.name, cap(d.requests))
+		return false
+	}
+}
+
+// serveSync queues a sync of every source, or of the source query
+// parameter, only its tag query parameter when set.
+func (d *daemon) serveSync(w http.ResponseWriter, r *http.Request) {
+	repository, tag := r.URL.Query().Get("source"), r.URL.Query().Get("tag")
+
+	var request syncRequest
+	if repository == "" {
+		d.mu.Lock()
+		request = syncRequest{name: "sources", conf: d.conf, tag: tag}
+		d.mu.Unlock()
+	} else {
+		var ok bool
+		var err error
+		request, ok, err = d.sourceRequest(repository, tag)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no source matches %s", repository)})
+			return
+		}
+	}
+
+	if !d.queue(request) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "too many sync requests"})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string][]string{"queued": {request.name}})
+}
+
+// serveWebhook queues a sync of each pushed tag of a source, the format
+// being the last path element (/webhooks/harbor).
+func (d *daemon) serveWebhook(w http.ResponseWriter, r *http.Request) {
+	format := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	pushes, err := webhook.Parse(format, body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	queued, dropped := []string{}, []string{}
+	for _, push := range pushes {
+		request, ok, err := d.sourceRequest(push.Repository, push.Tag)
+		if err != nil || !ok {
+			log.Debugf("%s : pushed %s matches no source, ignored", push.Repository, push.Tag)
+			continue
+		}
+		if !d.queue(request) {
+			dropped = append(dropped, request.name)
+			continue
+		}
+		queued = append(queued, request.name)
+	}
+	if len(queued) == 0 && len(dropped) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"error": "too many sync requests", "dropped": dropped})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string][]string{"queued": queued, "dropped": dropped})
+}
@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/barthv/imgsync/internal/config"
+)
+
+func TestDaemonHandler(t *testing.T) {
+	d := &daemon{requests: make(chan syncRequest, maxSyncRequests)}
+	handler := d.handler("secret")
+
+	get := func(path string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+	post := func(path string, body string, header string) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("got healthz %d", code)
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("got readyz %d before config load", code)
+	}
+
+	d.conf = config.Config{
+		Target: config.Repo{Host: "mirror.local"},
+		Sources: []config.Source{
+			{Source: config.Repo{Repository: "nginx"}},
+			{Source: config.Repo{Host: "registry.example.com:5000", Repository: "team/*"}},
+		},
+	}
+	d.ready = true
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("got readyz %d", code)
+	}
+
+	var tests = []struct {
+		name   string
+		path   string
+		body   string
+		header string
+		want   int
+		queued []syncRequest
+	}{
+		{"no token", "/sync", "", "", http.StatusUnauthorized, nil},
+		{"GET", "/sync?token=secret", "", "", http.StatusAccepted, []syncRequest{{name: "sources"}}},
+		{"one source tag", "/sync?source=nginx&tag=1.25", "", "Bearer secret", http.StatusAccepted, []syncRequest{{name: "index.docker.io/nginx:1.25", tag: "1.25"}}},
+		{"unknown source", "/sync?source=redis", "", "Bearer secret", http.StatusNotFound, nil},
+		{
+			"distribution webhook",
+			"/webhooks/distribution?token=secret",
+			`{"events": [{"action": "push", "target": {"repository": "team/app", "tag": "2.0"}, "request": {"host": "registry.example.com:5000"}},
+				{"action": "push", "target": {"repository": "other/app", "tag": "2.0"}, "request": {"host": "registry.example.com:5000"}}]}`,
+			"",
+			http.StatusAccepted,
+			[]syncRequest{{name: "registry.example.com:5000/team/app:2.0", tag: "2.0"}},
+		},
+		{"unknown webhook", "/webhooks/quay?token=secret", `{}`, "", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.name == "GET" {
+				// POST endpoints refuse other methods.
+				if code := get(test.path); code != http.StatusMethodNotAllowed {
+					t.Errorf("got %d for GET", code)
+				}
+			}
+			if code := post(test.path, test.body, test.header); code != test.want {
+				t.Errorf("got %d, want %d", code, test.want)
+			}
+			for _, want := range test.queued {
+				select {
+				case request := <-d.requests:
+					if request.name != want.name || request.tag != want.tag {
+						t.Errorf("got request %s %s, want %s %s", request.name, request.tag, want.name, want.tag)
+					}
+				default:
+					t.Errorf("got no request, want %s", want.name)
+				}
+			}
+			if len(d.requests) > 0 {
+				t.Errorf("got %d unexpected requests", len(d.requests))
+			}
+		})
+	}
+}
+
+func TestDaemonWebhookDropped(t *testing.T) {
+	d := &daemon{requests: make(chan syncRequest, 1)}
+	d.conf = config.Config{
+		Target:  config.Repo{Host: "mirror.local"},
+		Sources: []config.Source{{Source: config.Repo{Host: "registry.example.com:5000", Repository: "team/*"}}},
+	}
+	body := `{"events": [{"action": "push", "target": {"repository": "team/app", "tag": "1.0"}, "request": {"host": "registry.example.com:5000"}},
+		{"action": "push", "target": {"repository": "team/app", "tag": "2.0"}, "request": {"host": "registry.example.com:5000"}}]}`
+
+	recorder := httptest.NewRecorder()
+	d.handler("secret").ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhooks/distribution?token=secret", strings.NewReader(body)))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("got %d, want %d", recorder.Code, http.StatusAccepted)
+	}
+	var got map[string][]string
+	if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got["queued"]) != 1 || got["queued"][0] != "registry.example.com:5000/team/app:1.0" {
+		t.Errorf("got queued %v", got["queued"])
+	}
+	if len(got["dropped"]) != 1 || got["dropped"][0] != "registry.example.com:5000/team/app:2.0" {
+		t.Errorf("got dropped %v", got["dropped"])
+	}
+}
+
+func TestRedactToken(t *testing.T) {
+	var tests = []struct {
+		url  string
+		want string
+	}{
+		{"/sync?source=nginx&token=secret", "/sync?source=nginx&token=REDACTED"},
+		{"/webhooks/harbor", "/webhooks/harbor"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactToken(u); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+// expandSources replaces every source selecting repositories by pattern
+// with a source for each matching repository of its registry.
+func expandSources(conf config.Config, retry retryPolicy) ([]config.Source, error) {
+	sources := []config.Source{}
+	for _, source := range conf.Sources {
+		if !source.IsRepositoryPattern() {
+			sources = append(sources, source)
+			continue
+		}
+
+		pattern := source.Source.Repository
+		if source.Source.RepositoryRegex != "" {
+			pattern = source.Source.RepositoryRegex
+		}
+		sourceType, err := source.Source.GetType()
+		if err != nil {
+			return nil, err
+		}
+		if sourceType != config.RepoTypeRegistry {
+			return nil, fmt.Errorf("%s : repository patterns require a registry source", pattern)
+		}
+
+		if err := setHostLimits(source.Source); err != nil {
+			return nil, err
+		}
+		if source.Source.Auth.Username != "" {
+			log.Debugf("%s : encoding source credentials", pattern)
+			err := repo.SetHostCredentials(source.Source.GetRepositoryAddress(), source.Source.Auth.Username, source.Source.Auth.Password)
+			if err != nil {
+				log.Errorf("source auth failed : %s", err)
+				return nil, err
+			}
+		}
+
+		var repositories []string
+		_, err = retry.do(pattern, func() error {
+			var err error
+			repositories, err = repo.ListRepositories(source.Source.Host, source.RepositoryNamespace())
+			return err
+		})
+		if conf.ContinueOnSyncError && err != nil {
+			log.Errorf("%s", err)
+			log.Warnln("continueOnSyncError flag enabled : List repositories error ignored.")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		expanded, err := source.ExpandRepositories(repositories)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("%s : %d/%d repositories matching", pattern, len(expanded), len(repositories))
+		sources = append(sources, expanded...)
+	}
+	return sources, nil
+}
@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/state"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newStatusCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "status",
+		Short: "print freshness and last failures of each source from the state file",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := runStatusCommand(); err != nil {
+				return fmt.Errorf("status command: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	return &cmd
+}
+
+// runStatusCommand only reads the state file, registries are not contacted.
+func runStatusCommand() error {
+	conf, err := config.Get(viper.GetString("confpath"))
+	if err != nil {
+		return err
+	}
+	if conf.StateFile == "" {
+		return fmt.Errorf("status requires a stateFile")
+	}
+
+	store, err := state.Load(conf.StateFile)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	failures := []state.TagState{}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tTARGET\tTAGS\tSIZE\tLAST SYNC\tFAILURES")
+	sources, err := stateSources(conf, store)
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		sourceRepoAddr := source.Source.GetRepositoryAddress()
+		targetRepoAddr := source.GetTargetRepositoryAddress(conf.Target)
+
+		summary := store.Summarize(sourceRepoAddr, targetRepoAddr, []string{statusFailed, statusRefused, statusMutated})
+		lastSync := "never"
+		if !summary.LastSyncedAt.IsZero() {
+			lastSync = formatAge(now.Sub(summary.LastSyncedAt)) + " ago"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%d\n", sourceRepoAddr, targetRepoAddr, summary.Synced, formatSize(summary.Size), lastSync, len(summary.Failures))
+		failures = append(failures, summary.Failures...)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tTAG\tOUTCOME\tWHEN\tERROR")
+	for _, failure := range failures {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\n", failure.Source, failure.Tag, failure.Outcome, formatAge(now.Sub(failure.AttemptedAt)), failure.Error)
+	}
+	return w.Flush()
+}
+
+// stateSources returns the sources of conf, with repository patterns
+// expanded to the repositories recorded in the state. Patterns without
+// recorded repositories are kept as-is.
+func stateSources(conf config.Config, store *state.Store) ([]config.Source, error) {
+	recorded := []string{}
+	for _, tag := range store.Tags() {
+		if !stringInSlice(tag.Source, recorded) {
+			recorded = append(recorded, tag.Source)
+		}
+	}
+
+	sources := []config.Source{}
+	for _, source := range conf.Sources {
+		if !source.IsRepositoryPattern() {
+			sources = append(sources, source)
+			continue
+		}
+		pattern := config.Config{Sources: []config.Source{source}}
+		expanded := 0
+		for _, repository := range recorded {
+			match, ok, err := pattern.MatchSource(repository)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				sources = append(sources, match)
+				expanded++
+			}
+		}
+		if expanded == 0 {
+			sources = append(sources, source)
+		}
+	}
+	return sources, nil
+}
+
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%ds", int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	}
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
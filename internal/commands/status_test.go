@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/state"
+)
+
+func TestStateSources(t *testing.T) {
+	store, err := state.Load(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{"index.docker.io/bitnami/redis", "index.docker.io/bitnami/nginx", "index.docker.io/library/nginx"} {
+		store.Set(state.TagState{Source: source, Target: "mirror.local/x", Tag: "1.0"})
+	}
+	conf := config.Config{
+		Target: config.Repo{Host: "mirror.local"},
+		Sources: []config.Source{
+			{Source: config.Repo{Repository: "nginx"}},
+			{Source: config.Repo{Repository: "bitnami/*"}},
+			{Source: config.Repo{Host: "quay.io", RepositoryRegex: "^team/"}},
+		},
+	}
+
+	sources, err := stateSources(conf, store)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	want := []string{"index.docker.io/nginx", "index.docker.io/bitnami/nginx", "index.docker.io/bitnami/redis", "quay.io/"}
+	if len(sources) != len(want) {
+		t.Fatalf("got %d sources, want %v", len(sources), want)
+	}
+	for i, source := range sources {
+		if got := source.Source.GetRepositoryAddress(); got != want[i] {
+			t.Errorf("got source %s, want %s", got, want[i])
+		}
+	}
+}
@@ -1,10 +1,19 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/barthv/imgsync/internal/config"
 	"github.com/barthv/imgsync/internal/repo"
+	"github.com/barthv/imgsync/internal/rewrite"
+	"github.com/barthv/imgsync/internal/state"
+	"github.com/barthv/imgsync/internal/verify"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -24,20 +33,100 @@ func newSyncCommand() *cobra.Command {
 		},
 	}
 
+	cmd.Flags().Bool("locked", false, "Sync exactly the digests recorded in the lock file")
+	viper.BindPFlag("locked", cmd.Flags().Lookup("locked"))
+	cmd.Flags().String("rewrite-map", "", "Write the map of source tags to mirrored tags to this file after sync")
+	viper.BindPFlag("syncRewriteMap", cmd.Flags().Lookup("rewrite-map"))
+	cmd.Flags().String("rewrite-map-format", "", "Rewrite map format: json, yaml, kustomize, hosts.toml or registries.conf (default from the file extension)")
+	viper.BindPFlag("syncRewriteMapFormat", cmd.Flags().Lookup("rewrite-map-format"))
+
 	return &cmd
 }
 
-func runSyncCommand() error {
-	// ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	// defer cancel()
+// ErrTagMutation is returned when failOnTagMutation is enabled and an
+// immutable tag changed upstream.
+var ErrTagMutation = errors.New("immutable tags changed upstream")
+
+// stateSaveInterval is how often the state file is saved during a run, it
+// is saved again once the run ends.
+const stateSaveInterval = 30 * time.Second
 
+func runSyncCommand() error {
 	conf, err := config.Get(viper.GetString("confpath"))
 	if err != nil {
 		return err
 	}
 
+	// On interrupt, the run stops once in-flight copies are done and
+	// saves the state file.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	go func() {
+		var sig os.Signal
+		select {
+		case sig = <-signals:
+		case <-ctx.Done():
+			return
+		}
+		log.Infof("%s received, stopping once in-flight copies are done", sig)
+		cancel()
+		<-signals
+		log.Warnln("Second signal received, exiting now")
+		os.Exit(1)
+	}()
+
+	return runSync(ctx, conf, "")
+}
+
+// runSync syncs the sources of a config, only tag when set and selected.
+// Once ctx is done, in-flight copies finish and remaining tags are left
+// for the next run.
+func runSync(ctx context.Context, conf config.Config, tag string) error {
+	// Settings of a previous run, e.g. before a daemon config reload, must
+	// not leak into this one.
+	repo.Reset()
+
+	if (conf.DetectTagMutation || conf.FailOnTagMutation) && conf.StateFile == "" {
+		return fmt.Errorf("tag mutation detection requires a stateFile")
+	}
+
+	var lock *config.Lock
+	if viper.GetBool("locked") {
+		lockLocation := config.GetLockLocation(viper.GetString("confpath"))
+		l, err := config.GetLock(lockLocation)
+		if err != nil {
+			return err
+		}
+		lock = &l
+		log.Infof("Syncing digests locked in %s", lockLocation)
+	}
+
+	retry, err := newRetryPolicy(ctx, conf.Retry)
+	if err != nil {
+		return err
+	}
+
 	targetAddr := conf.Target.GetRepositoryAddress()
 
+	rateLimitMaxWait, err := conf.GetRateLimitMaxWait()
+	if err != nil {
+		return err
+	}
+	repo.SetRateLimitMaxWait(rateLimitMaxWait)
+	bandwidthLimit, err := config.ParseBandwidth(conf.BandwidthLimit)
+	if err != nil {
+		return err
+	}
+	if bandwidthLimit > 0 {
+		repo.SetBandwidthLimit(bandwidthLimit)
+	}
+	if err := setHostLimits(conf.Target); err != nil {
+		return err
+	}
+
 	if conf.Target.Auth.Username != "" {
 		log.Debugln("Encoding target credentials")
 		err := repo.SetHostCredentials(targetAddr, conf.Target.Auth.Username, conf.Target.Auth.Password)
@@ -47,20 +136,74 @@ func runSyncCommand() error {
 		}
 	}
 
-	if err := conf.Target.Healthcheck(); err != nil {
-		log.Debugln("target test : %s", err)
-		log.Errorln("Target registry is unavailable. Stopping")
+	dest, err := newDestination(conf.Target)
+	if err != nil {
+		return err
+	}
+	repo.SetDestination(dest)
+
+	if _, ok := dest.(repo.Registry); !ok {
+		log.Infof("Images will be synced to %s %s", conf.Target.Type, conf.Target.Path)
+	} else {
+		if err := conf.Target.Healthcheck(); err != nil {
+			log.Debugf("target test : %s", err)
+			log.Errorln("Target registry is unavailable. Stopping")
+			return err
+		}
+
+		log.Debugln("Target registry is healthy")
+		log.Infof("Images will be synced to %s", targetAddr)
+	}
+
+	layerCache, _, err := openCache(conf)
+	if err != nil {
 		return err
 	}
+	if layerCache != nil {
+		log.Debugf("Caching layers in %s", conf.CacheDir)
+		repo.SetLayerCache(layerCache)
+	}
+
+	printer := newProgressPrinter(os.Stdout)
+	repo.SetProgressHandler(printer.update)
+	defer printer.routeLogs()()
 
-	log.Debugln("Target registry is healthy")
-	log.Infof("Images will be synced to %s", targetAddr)
+	report := newSyncReport()
+	defer report.print()
+
+	var store *state.Store
+	if conf.StateFile != "" {
+		store, err = state.Load(conf.StateFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := store.Save(); err != nil {
+				log.Errorf("%s", err)
+			}
+		}()
+	}
 
-	for _, source := range conf.Sources {
+	syncs := []*sourceSync{}
+	sources, err := expandSources(conf, retry)
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			break
+		}
 		log.Infof("Starting sync : %s", source.Source.Repository)
 
 		sourceRepoAddr := source.Source.GetRepositoryAddress()
 
+		if err := setHostLimits(source.Source); err != nil {
+			return err
+		}
+		if err := setSource(source.Source); err != nil {
+			return err
+		}
+
 		if source.Source.Auth.Username != "" {
 			log.Debugf("%s : encoding source credentials", sourceRepoAddr)
 			err := repo.SetHostCredentials(sourceRepoAddr, source.Source.Auth.Username, source.Source.Auth.Password)
@@ -70,7 +213,12 @@ func runSyncCommand() error {
 			}
 		}
 
-		sourceRepoTags, err := repo.ListRepo(sourceRepoAddr)
+		var sourceRepoTags []string
+		_, err := retry.do(sourceRepoAddr, func() error {
+			var err error
+			sourceRepoTags, err = repo.ListRepo(sourceRepoAddr)
+			return err
+		})
 		if conf.ContinueOnSyncError && err != nil {
 			log.Debugf("%s", err)
 			log.Warnln("continueOnSyncError flag enabled : List source error ignored.")
@@ -83,14 +231,118 @@ func runSyncCommand() error {
 		targetRepoAddr := source.GetTargetRepositoryAddress(conf.Target)
 		log.Infof("%s : target repo is %s", sourceRepoAddr, targetRepoAddr)
 
+		var verifier *verify.Verifier
+		if source.Verify != nil {
+			verifier, err = verify.New(*source.Verify)
+			if err != nil {
+				return err
+			}
+			if source.Verify.Key == "" {
+				log.Warnf("%s : keyless verification does not check the transparency log, it is not a supply-chain guarantee", sourceRepoAddr)
+			}
+		}
+
+		var targetRepoTags []string
+		_, err = retry.do(targetRepoAddr, func() error {
+			var err error
+			targetRepoTags, err = dest.ListTags(targetRepoAddr)
+			if repo.ClassifyError(err) == repo.ErrorClassNotFound {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			log.Debugf("%s", err)
+		}
+
+		s := &sourceSync{
+			ctx:            ctx,
+			conf:           conf,
+			source:         source,
+			sourceRepoAddr: sourceRepoAddr,
+			targetRepoAddr: targetRepoAddr,
+			sourceRepoTags: sourceRepoTags,
+			targetRepoTags: targetRepoTags,
+			platformTags:   map[string]map[string]string{},
+			verifier:       verifier,
+			store:          store,
+			report:         report,
+			retry:          retry,
+		}
+		syncs = append(syncs, s)
+
+		if lock != nil {
+			if err := s.syncLockedTags(lock, targetRepoTags); err != nil {
+				return err
+			}
+			continue
+		}
+
 		sourceFilteredTags, err := source.FilterTags(sourceRepoTags)
 		if err != nil {
 			return err
 		}
 		log.Infof("%s : %d/%d tags matching selectors", sourceRepoAddr, len(sourceFilteredTags), len(sourceRepoTags))
+		// Arch suffixed tags are only assembled with their whole group.
+		if tag != "" && !source.AssembleArchTags() {
+			if !stringInSlice(tag, sourceFilteredTags) && !stringInSlice(tag, source.MutableTags) {
+				log.Infof("%s : %s not selected, skipped", sourceRepoAddr, tag)
+				continue
+			}
+			if stringInSlice(tag, sourceFilteredTags) {
+				sourceFilteredTags = []string{tag}
+			} else {
+				sourceFilteredTags = []string{}
+			}
+			if stringInSlice(tag, source.MutableTags) {
+				source.MutableTags = []string{tag}
+			} else {
+				source.MutableTags = nil
+			}
+			s.source = source
+		}
+		s.selectedTags = append(append([]string{}, sourceFilteredTags...), config.MissingTags(source.MutableTags, sourceFilteredTags)...)
+
+		if source.AssembleArchTags() {
+			err = s.syncArchTagGroups(sourceFilteredTags, targetRepoTags)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
-		targetRepoTags, _ := repo.ListRepo(targetRepoAddr)
 		missingTags := config.MissingTags(sourceFilteredTags, targetRepoTags)
+
+		// Synced tags missing derived platform tags are split again.
+		resplitTags := []string{}
+		if source.SplitPlatformTags() {
+			resplitTags, err = source.MissingPlatformTags(config.MissingTags(config.MissingTags(sourceFilteredTags, missingTags), source.MutableTags), targetRepoTags)
+			if err == nil {
+				listedTags := append(append(append([]string{}, missingTags...), source.MutableTags...), resplitTags...)
+				s.platformTags, err = listPlatformTags(source, listedTags, source.TagPins())
+			}
+			if conf.ContinueOnSyncError && err != nil {
+				log.Errorf("%s", err)
+				log.Warnln("continueOnSyncError flag enabled : List platforms error ignored.")
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			resplitTags = missingPlatformTags(s.platformTags, resplitTags, targetRepoTags)
+		}
+
+		if conf.DetectTagMutation || conf.FailOnTagMutation {
+			syncedTags := config.MissingTags(sourceFilteredTags, missingTags)
+			err = checkTagMutations(store, syncedTags, sourceRepoAddr, targetRepoAddr, report)
+			if conf.ContinueOnSyncError && err != nil {
+				log.Errorf("%s", err)
+				log.Warnln("continueOnSyncError flag enabled : Tag mutation check error ignored.")
+			} else if err != nil {
+				return err
+			}
+		}
+		s.syncMissingArtifacts(config.MissingTags(config.MissingTags(sourceFilteredTags, missingTags), source.MutableTags))
 		allSyncTags := append(missingTags, source.MutableTags...)
 		if len(missingTags) > 0 {
 			log.Infof("%s : %d missing tags to sync", sourceRepoAddr, len(missingTags))
@@ -99,23 +351,218 @@ func runSyncCommand() error {
 			log.Infof("%s : %d tags forced to sync", sourceRepoAddr, len(source.MutableTags))
 		}
 
-		if len(allSyncTags) == 0 {
+		if len(resplitTags) > 0 {
+			log.Infof("%s : %d synced tags missing platform tags", sourceRepoAddr, len(resplitTags))
+		}
+
+		if len(allSyncTags) == 0 && len(resplitTags) == 0 {
 			log.Infof("%s : target is up-to-date", sourceRepoAddr)
 			continue
 		}
 
+		pins := source.TagPins()
+		for _, tag := range resplitTags {
+			if s.stopped() {
+				break
+			}
+			if err := s.splitTag(tag, pins[tag]); err != nil {
+				return err
+			}
+		}
 		for _, tag := range allSyncTags {
-			log.Infof("%s : syncing %s to %s:%s", sourceRepoAddr, tag, targetRepoAddr, tag)
-			err = repo.SyncTagBetweenRepos(tag, sourceRepoAddr, targetRepoAddr)
-			if conf.ContinueOnSyncError && err != nil {
-				log.Errorf("%s", err)
-				log.Warnln("continueOnSyncError flag enabled : Sync error ignored.")
-				continue
+			if s.stopped() {
+				break
 			}
-			if err != nil {
+			if err := s.syncTag(tag, pins[tag], false); err != nil {
 				return err
 			}
 		}
 	}
+
+	for _, s := range syncs {
+		if err := s.syncDeferredTags(); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		log.Warnln("Sync interrupted, remaining tags are left for the next run")
+	}
+
+	if path := viper.GetString("syncRewriteMap"); path != "" {
+		if err := writeRewriteMap(path, viper.GetString("syncRewriteMapFormat"), syncs, dest); err != nil {
+			return err
+		}
+	}
+
+	if conf.FailOnTagMutation && report.count(statusMutated) > 0 {
+		return ErrTagMutation
+	}
+	return nil
+}
+
+// writeRewriteMap writes the rewrite map of every source to a file.
+func writeRewriteMap(path string, format string, syncs []*sourceSync, dest repo.Destination) error {
+	if format == "" {
+		format = rewrite.FormatFromPath(path)
+	}
+	m := rewrite.Map{}
+	for _, s := range syncs {
+		m = append(m, s.rewriteEntries(dest)...)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(f, format); err != nil {
+		f.Close()
+		return fmt.Errorf("rewrite map : %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Infof("Rewrite map of %d tags written to %s", len(m), path)
+	return nil
+}
+
+// newDestination returns where images are written for a target.
+func newDestination(target config.Repo) (repo.Destination, error) {
+	targetType, err := target.GetType()
+	if err != nil {
+		return nil, err
+	}
+	switch targetType {
+	case config.RepoTypeOCILayout:
+		return repo.NewOCILayout(target.Path), nil
+	case config.RepoTypeDockerArchive:
+		return repo.NewDockerArchive(target.Path), nil
+	}
+	return repo.Registry{}, nil
+}
+
+// setSource reads a source repository from its OCI layout or archive,
+// when it is not a registry.
+func setSource(r config.Repo) error {
+	sourceType, err := r.GetType()
+	if err != nil {
+		return err
+	}
+	switch sourceType {
+	case config.RepoTypeOCILayout:
+		return repo.SetSource(r.GetRepositoryAddress(), repo.NewOCILayout(r.Path))
+	case config.RepoTypeDockerArchive:
+		return repo.SetSource(r.GetRepositoryAddress(), repo.NewDockerArchive(r.Path))
+	}
+	return nil
+}
+
+// setHostLimits applies the request rate and bandwidth limits of a
+// registry host.
+func setHostLimits(r config.Repo) error {
+	addr := r.GetRepositoryAddress()
+	if r.RequestsPerMinute > 0 {
+		log.Debugf("%s : throttled to %d requests per minute", addr, r.RequestsPerMinute)
+		if err := repo.SetHostRequestsPerMinute(addr, r.RequestsPerMinute); err != nil {
+			return err
+		}
+	}
+
+	bandwidthLimit, err := config.ParseBandwidth(r.BandwidthLimit)
+	if err != nil {
+		return err
+	}
+	if bandwidthLimit > 0 {
+		log.Debugf("%s : bandwidth limited to %s", addr, r.BandwidthLimit)
+		return repo.SetHostBandwidthLimit(addr, bandwidthLimit)
+	}
+	return nil
+}
+
+// checkTagMutations compares the current source digest of already synced
+// immutable tags with the digest recorded when they were synced.
+// Tags synced before any state was recorded are adopted as-is. Tags whose
+// digest can't be read are reported and skipped.
+func checkTagMutations(store *state.Store, tags []string, sourceRepoAddr string, targetRepoAddr string, report *syncReport) error {
+	failed := 0
+	for _, tag := range tags {
+		digest, err := repo.TagDigest(tag, sourceRepoAddr)
+		if err != nil {
+			log.Errorf("%s : checking %s for mutation : %s", sourceRepoAddr, tag, err)
+			failed++
+			continue
+		}
+
+		recorded, ok := store.Get(sourceRepoAddr, targetRepoAddr, tag)
+		if !ok || recorded.Digest == "" {
+			log.Debugf("%s : recording %s digest %s", sourceRepoAddr, tag, digest)
+			store.Set(state.TagState{Source: sourceRepoAddr, Target: targetRepoAddr, Tag: tag, Digest: digest})
+			continue
+		}
+
+		if recorded.Digest != digest {
+			message := fmt.Sprintf("digest changed upstream from %s to %s", recorded.Digest, digest)
+			log.Warnf("%s : immutable tag %s %s", sourceRepoAddr, tag, message)
+			report.add(sourceRepoAddr, tag, statusMutated, message, 1)
+			recorded.Outcome = statusMutated
+			recorded.Error = message
+			recorded.AttemptedAt = time.Now().UTC()
+			store.Set(recorded)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%s : %d of %d tags could not be checked for mutation", sourceRepoAddr, failed, len(tags))
+	}
 	return nil
 }
+
+// listPlatformTags returns, for each multi-arch source tag, the derived
+// target tag of each of its platforms. Tags found in digests are looked
+// up by digest. Two platforms deriving the same tag, e.g. arm/v6 and
+// arm/v7 without {{.Variant}} in the format, are an error.
+// missingPlatformTags returns the tags of tags with a derived platform tag
+// not in targetTags.
+func missingPlatformTags(platformTags map[string]map[string]string, tags []string, targetTags []string) []string {
+	missing := []string{}
+	for _, tag := range tags {
+		for _, platformTag := range platformTags[tag] {
+			if !stringInSlice(platformTag, targetTags) {
+				missing = append(missing, tag)
+				break
+			}
+		}
+	}
+	return missing
+}
+
+func listPlatformTags(source config.Source, tags []string, digests map[string]string) (map[string]map[string]string, error) {
+	sourceRepoAddr := source.Source.GetRepositoryAddress()
+	platformTags := map[string]map[string]string{}
+	derivedFrom := map[string]string{}
+
+	for _, tag := range tags {
+		sourceRef := tag
+		if digest, ok := digests[tag]; ok {
+			sourceRef = digest
+		}
+		platforms, err := repo.ListTagPlatforms(sourceRef, sourceRepoAddr, source.Platforms)
+		if err != nil {
+			return platformTags, err
+		}
+		for _, platform := range platforms {
+			platformTag, err := source.PlatformTag(tag, platform)
+			if err != nil {
+				return platformTags, err
+			}
+			if from, ok := derivedFrom[platformTag]; ok {
+				return platformTags, fmt.Errorf("%s : %s and %s %s both derive tag %s, check platformTagFormat", sourceRepoAddr, from, tag, platform, platformTag)
+			}
+			derivedFrom[platformTag] = tag + " " + platform
+			if _, ok := platformTags[tag]; !ok {
+				platformTags[tag] = map[string]string{}
+			}
+			platformTags[tag][platform] = platformTag
+		}
+	}
+
+	return platformTags, nil
+}
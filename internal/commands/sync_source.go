@@ -0,0 +1,527 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/repo"
+	"github.com/barthv/imgsync/internal/rewrite"
+	"github.com/barthv/imgsync/internal/state"
+	"github.com/barthv/imgsync/internal/verify"
+	log "github.com/sirupsen/logrus"
+)
+
+// sourceSync holds everything needed to sync the tags of a single source.
+type sourceSync struct {
+	// ctx stops the sync between two tags once done.
+	ctx            context.Context
+	conf           config.Config
+	source         config.Source
+	sourceRepoAddr string
+	targetRepoAddr string
+	sourceRepoTags []string
+	targetRepoTags []string
+	// selectedTags are the source tags the target should hold.
+	selectedTags []string
+	// platformTags maps multi-arch tags to their derived platform tags.
+	platformTags map[string]map[string]string
+	verifier     *verify.Verifier
+	store        *state.Store
+	report       *syncReport
+	retry        retryPolicy
+	// retries counts retries made for the tag being synced.
+	retries int
+	// deferred are tags postponed to the end of the run because a
+	// registry host ran out of rate limit budget, synced when final is set.
+	deferred []deferredTag
+	final    bool
+}
+
+type deferredTag struct {
+	tag    string
+	digest string
+	locked bool
+}
+
+// rateLimited tells if the source or target host has no request budget
+// left, and when it resets.
+func (s *sourceSync) rateLimited() (time.Time, bool) {
+	for _, addr := range []string{s.sourceRepoAddr, s.targetRepoAddr} {
+		if reset := repo.HostRateLimitReset(addr); !reset.IsZero() {
+			return reset, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// postpone defers a tag to the end of the run, unless deferred tags are
+// already being synced.
+func (s *sourceSync) postpone(tag string, digest string, locked bool) bool {
+	if s.final {
+		return false
+	}
+	log.Warnf("%s : rate limited, %s postponed to the end of the run", s.sourceRepoAddr, tag)
+	s.deferred = append(s.deferred, deferredTag{tag: tag, digest: digest, locked: locked})
+	s.retries = 0
+	return true
+}
+
+// stopped tells if the run was asked to stop.
+func (s *sourceSync) stopped() bool {
+	return s.ctx.Err() != nil
+}
+
+// syncDeferredTags syncs the tags postponed because of rate limits.
+func (s *sourceSync) syncDeferredTags() error {
+	if len(s.deferred) == 0 {
+		return nil
+	}
+	log.Infof("%s : syncing %d postponed tags", s.sourceRepoAddr, len(s.deferred))
+
+	s.final = true
+	for _, d := range s.deferred {
+		if s.stopped() {
+			break
+		}
+		if err := s.syncTag(d.tag, d.digest, d.locked); err != nil {
+			return err
+		}
+	}
+	s.deferred = nil
+	return nil
+}
+
+// record reports the outcome of a tag and, with a state file, records it
+// and saves the file every stateSaveInterval so a crashed run can be
+// resumed. Synced tags keep their previous digest when none is known.
+func (s *sourceSync) record(tag string, status string, message string, digest string) {
+	s.report.add(s.sourceRepoAddr, tag, status, message, s.retries+1)
+	s.retries = 0
+	if s.store == nil || tag == "" {
+		return
+	}
+
+	now := time.Now().UTC()
+	tagState, _ := s.store.Get(s.sourceRepoAddr, s.targetRepoAddr, tag)
+	tagState.Source = s.sourceRepoAddr
+	tagState.Target = s.targetRepoAddr
+	tagState.Tag = tag
+	tagState.Outcome = status
+	tagState.Error = message
+	tagState.AttemptedAt = now
+	if status == statusSynced {
+		if digest != "" {
+			tagState.Digest = digest
+		}
+		tagState.SyncedAt = now
+		size, err := repo.TagSize(tag, s.targetRepoAddr)
+		if err != nil {
+			log.Debugf("%s", err)
+		}
+		tagState.Size = size
+	}
+	s.store.Set(tagState)
+
+	if err := s.store.SaveEvery(stateSaveInterval); err != nil {
+		log.Errorf("%s", err)
+	}
+}
+
+// upToDate tells if a tag was already synced from its current source
+// digest by a previous run, and is still in the target.
+func (s *sourceSync) upToDate(tag string, digest string) bool {
+	if s.store == nil || digest == "" || !stringInSlice(tag, s.targetRepoTags) {
+		return false
+	}
+	recorded, ok := s.store.Get(s.sourceRepoAddr, s.targetRepoAddr, tag)
+	return ok && recorded.Outcome == statusSynced && recorded.Digest == digest
+}
+
+// withRetry runs a sync operation of tag with the retry policy.
+func (s *sourceSync) withRetry(tag string, operation func() error) error {
+	attempts, err := s.retry.do(s.sourceRepoAddr+" : "+tag, operation)
+	s.retries += attempts - 1
+	return err
+}
+
+// failed reports a tag sync error. The error is returned only when the
+// run must stop, i.e. when continueOnSyncError is disabled.
+func (s *sourceSync) failed(tag string, err error) error {
+	message := err.Error()
+	if class := repo.ClassifyError(err); class != repo.ErrorClassUnknown {
+		message = fmt.Sprintf("%s error : %s", class, message)
+	}
+	s.record(tag, statusFailed, message, "")
+	if s.conf.ContinueOnSyncError {
+		log.Errorf("%s", err)
+		log.Warnln("continueOnSyncError flag enabled : Sync error ignored.")
+		return nil
+	}
+	return err
+}
+
+func (s *sourceSync) refused(tag string, err error) {
+	log.Errorf("%s : refusing to sync %s : %s", s.sourceRepoAddr, tag, err)
+	s.record(tag, statusRefused, err.Error(), "")
+}
+
+// syncTag syncs a single tag, with its derived platform tags and artifacts.
+// When digest is set, this exact manifest is copied to the tag: pinned
+// digests must still match upstream, locked digests are copied as-is.
+func (s *sourceSync) syncTag(tag string, digest string, locked bool) error {
+	if _, ok := s.rateLimited(); ok && s.postpone(tag, digest, locked) {
+		return nil
+	}
+
+	if digest != "" && !locked {
+		currentDigest, err := repo.TagDigest(tag, s.sourceRepoAddr)
+		if err != nil {
+			return s.failed(tag, err)
+		}
+		if currentDigest != digest {
+			s.refused(tag, fmt.Errorf("upstream digest %s does not match pinned digest %s", currentDigest, digest))
+			return nil
+		}
+	}
+
+	// The tag is resolved once, so that the verified digest is the one
+	// copied even if the tag moves upstream meanwhile.
+	sourceDigest := digest
+	if sourceDigest == "" {
+		var err error
+		sourceDigest, err = repo.TagDigest(tag, s.sourceRepoAddr)
+		if repo.ClassifyError(err) == repo.ErrorClassRateLimit && s.postpone(tag, digest, locked) {
+			return nil
+		}
+		if err != nil {
+			return s.failed(tag, err)
+		}
+	}
+
+	if s.verifier != nil {
+		if err := s.verifier.VerifyTag(sourceDigest, s.sourceRepoAddr); err != nil {
+			s.refused(tag, err)
+			return nil
+		}
+		log.Debugf("%s : %s signature verified", s.sourceRepoAddr, tag)
+	}
+
+	if s.upToDate(tag, sourceDigest) {
+		log.Infof("%s : %s already synced from %s, skipped", s.sourceRepoAddr, tag, sourceDigest)
+		return nil
+	}
+
+	if digest != "" {
+		log.Infof("%s : syncing %s@%s to %s:%s", s.sourceRepoAddr, tag, digest, s.targetRepoAddr, tag)
+	} else {
+		log.Infof("%s : syncing %s to %s:%s", s.sourceRepoAddr, tag, s.targetRepoAddr, tag)
+	}
+	// Each step is retried on its own, so that a failed artifact copy
+	// does not push the tag again. Platform tags are pushed first: once
+	// the tag is in the target, it is complete and not synced again.
+	var err error
+	if len(s.platformTags[tag]) > 0 {
+		log.Infof("%s : splitting %s into %d platform tags", s.sourceRepoAddr, tag, len(s.platformTags[tag]))
+		err = s.withRetry(tag, func() error {
+			return repo.SplitTagBetweenRepos(sourceDigest, s.sourceRepoAddr, s.targetRepoAddr, s.platformTags[tag])
+		})
+	}
+	if err == nil {
+		err = s.withRetry(tag, func() error {
+			return repo.SyncDigestBetweenRepos(sourceDigest, tag, s.sourceRepoAddr, s.targetRepoAddr, s.source.Platforms)
+		})
+	}
+	if err == nil && s.source.SyncArtifacts {
+		err = s.withRetry(tag, func() error {
+			return s.syncArtifacts(tag)
+		})
+	}
+	if errors.Is(err, repo.ErrNoMatchingPlatform) {
+		log.Warnf("%s : skipping %s, no image matching platforms %v", s.sourceRepoAddr, tag, s.source.Platforms)
+		s.record(tag, statusSkipped, err.Error(), "")
+		return nil
+	}
+	if repo.ClassifyError(err) == repo.ErrorClassRateLimit && s.postpone(tag, digest, locked) {
+		return nil
+	}
+	if err != nil {
+		return s.failed(tag, err)
+	}
+
+	s.record(tag, statusSynced, "", sourceDigest)
+	return nil
+}
+
+// splitTag pushes the platform tags of a tag already in the target again,
+// from digest when set, else the digest it was synced from when recorded,
+// else its current source digest.
+func (s *sourceSync) splitTag(tag string, digest string) error {
+	sourceDigest := digest
+	if sourceDigest == "" && s.store != nil {
+		recorded, _ := s.store.Get(s.sourceRepoAddr, s.targetRepoAddr, tag)
+		sourceDigest = recorded.Digest
+	}
+	if sourceDigest == "" {
+		var err error
+		sourceDigest, err = repo.TagDigest(tag, s.sourceRepoAddr)
+		if err != nil {
+			return s.failed(tag, err)
+		}
+	}
+	if s.verifier != nil {
+		if err := s.verifier.VerifyTag(sourceDigest, s.sourceRepoAddr); err != nil {
+			s.refused(tag, err)
+			return nil
+		}
+	}
+
+	log.Infof("%s : splitting %s into %d platform tags", s.sourceRepoAddr, tag, len(s.platformTags[tag]))
+	err := s.withRetry(tag, func() error {
+		return repo.SplitTagBetweenRepos(sourceDigest, s.sourceRepoAddr, s.targetRepoAddr, s.platformTags[tag])
+	})
+	if err != nil {
+		return s.failed(tag, err)
+	}
+	return nil
+}
+
+// syncArtifacts copies signatures, attestations, SBOMs and referrers
+// attached to a synced tag.
+func (s *sourceSync) syncArtifacts(tag string) error {
+	copied, err := repo.SyncArtifactsBetweenRepos(tag, s.sourceRepoAddr, s.targetRepoAddr, s.sourceRepoTags)
+	if copied > 0 {
+		log.Infof("%s : %d artifacts of %s synced", s.sourceRepoAddr, copied, tag)
+	}
+	return err
+}
+
+// syncMissingArtifacts copies artifacts added upstream to tags synced by
+// previous runs. Errors are reported without stopping the sync.
+func (s *sourceSync) syncMissingArtifacts(tags []string) {
+	if !s.source.SyncArtifacts || len(tags) == 0 {
+		return
+	}
+	// Recorded digests are those of the target tags unless the tags were
+	// assembled or filtered by platform.
+	digests := map[string]string{}
+	if s.store != nil && !s.source.AssembleArchTags() && len(s.source.Platforms) == 0 {
+		for _, tag := range tags {
+			if recorded, ok := s.store.Get(s.sourceRepoAddr, s.targetRepoAddr, tag); ok && recorded.Outcome == statusSynced && recorded.Digest != "" {
+				digests[tag] = recorded.Digest
+			}
+		}
+	}
+	copied, err := repo.SyncMissingArtifacts(tags, digests, s.sourceRepoAddr, s.targetRepoAddr, s.sourceRepoTags, s.targetRepoTags)
+	if copied > 0 {
+		log.Infof("%s : %d artifacts of already synced tags synced", s.sourceRepoAddr, copied)
+	}
+	if err != nil {
+		log.Errorf("%s : %s", s.sourceRepoAddr, err)
+	}
+}
+
+// syncLockedTags syncs the tags recorded in the lock file for this source,
+// ignoring tag selectors.
+func (s *sourceSync) syncLockedTags(lock *config.Lock, targetRepoTags []string) error {
+	lockedTags, ok := lock.LockedTags(s.sourceRepoAddr)
+	if !ok {
+		log.Warnf("%s : source is not locked, skipped", s.sourceRepoAddr)
+		return nil
+	}
+	if s.source.AssembleArchTags() {
+		log.Warnf("%s : archSuffixes sources can't be synced in locked mode, skipped", s.sourceRepoAddr)
+		return nil
+	}
+
+	tags := []string{}
+	for tag := range lockedTags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	s.selectedTags = tags
+	log.Infof("%s : %d locked tags", s.sourceRepoAddr, len(tags))
+
+	syncTags := config.MissingTags(tags, targetRepoTags)
+	for _, tag := range s.source.MutableTags {
+		if _, ok := lockedTags[tag]; ok && !stringInSlice(tag, syncTags) {
+			syncTags = append(syncTags, tag)
+		}
+	}
+
+	resplitTags := []string{}
+	if s.source.SplitPlatformTags() {
+		var err error
+		resplitTags, err = s.source.MissingPlatformTags(config.MissingTags(config.MissingTags(tags, syncTags), s.source.MutableTags), targetRepoTags)
+		if err == nil {
+			s.platformTags, err = listPlatformTags(s.source, append(append([]string{}, syncTags...), resplitTags...), lockedTags)
+		}
+		if err != nil {
+			return s.failed("", err)
+		}
+		resplitTags = missingPlatformTags(s.platformTags, resplitTags, targetRepoTags)
+	}
+
+	if len(syncTags) == 0 && len(resplitTags) == 0 {
+		log.Infof("%s : target is up-to-date", s.sourceRepoAddr)
+		return nil
+	}
+
+	for _, tag := range resplitTags {
+		if s.stopped() {
+			break
+		}
+		if err := s.splitTag(tag, lockedTags[tag]); err != nil {
+			return err
+		}
+	}
+	for _, tag := range syncTags {
+		if s.stopped() {
+			break
+		}
+		if err := s.syncTag(tag, lockedTags[tag], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncArchTagGroups assembles arch-suffixed source tags into multi-arch
+// images pushed under their unsuffixed name.
+func (s *sourceSync) syncArchTagGroups(sourceFilteredTags []string, targetRepoTags []string) error {
+	groups := s.source.GroupArchTags(sourceFilteredTags)
+	groupTags := []string{}
+	for _, group := range groups {
+		groupTags = append(groupTags, group.Tag)
+	}
+	missingTags := config.MissingTags(groupTags, targetRepoTags)
+	s.selectedTags = groupTags
+	s.syncMissingArtifacts(config.MissingTags(config.MissingTags(groupTags, missingTags), s.source.MutableTags))
+
+	syncGroups := []config.ArchTagGroup{}
+	for _, group := range groups {
+		if stringInSlice(group.Tag, missingTags) {
+			syncGroups = append(syncGroups, group)
+		}
+	}
+	if len(syncGroups) > 0 {
+		log.Infof("%s : %d missing multi-arch tags to assemble", s.sourceRepoAddr, len(syncGroups))
+	}
+
+	mutableGroups := s.source.GroupArchTags(s.source.ExpandArchTags(s.source.MutableTags, s.sourceRepoTags))
+	if len(mutableGroups) > 0 {
+		log.Infof("%s : %d multi-arch tags forced to assemble", s.sourceRepoAddr, len(mutableGroups))
+	}
+	syncGroups = append(syncGroups, mutableGroups...)
+	for _, group := range mutableGroups {
+		if !stringInSlice(group.Tag, s.selectedTags) {
+			s.selectedTags = append(s.selectedTags, group.Tag)
+		}
+	}
+
+	if len(syncGroups) == 0 {
+		log.Infof("%s : target is up-to-date", s.sourceRepoAddr)
+		return nil
+	}
+
+	for _, group := range syncGroups {
+		if s.stopped() {
+			break
+		}
+		var digests map[string]string
+		if s.verifier != nil {
+			var err error
+			digests, err = s.resolveArchTagGroup(group)
+			if err != nil {
+				if err := s.failed(group.Tag, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.verifyArchTagGroup(group, digests); err != nil {
+				s.refused(group.Tag, err)
+				continue
+			}
+		}
+
+		log.Infof("%s : assembling %d tags to %s:%s", s.sourceRepoAddr, len(group.Members), s.targetRepoAddr, group.Tag)
+		var digest string
+		err := s.withRetry(group.Tag, func() error {
+			var err error
+			digest, err = repo.AssembleTagsBetweenRepos(group.Members, digests, group.Tag, s.sourceRepoAddr, s.targetRepoAddr, s.source.Platforms)
+			return err
+		})
+		if err == nil && s.source.SyncArtifacts {
+			err = s.withRetry(group.Tag, func() error {
+				return s.syncArtifacts(group.Tag)
+			})
+		}
+		if errors.Is(err, repo.ErrNoMatchingPlatform) {
+			log.Warnf("%s : skipping %s, no image matching platforms %v", s.sourceRepoAddr, group.Tag, s.source.Platforms)
+			s.record(group.Tag, statusSkipped, err.Error(), "")
+			continue
+		}
+		if err != nil {
+			if err := s.failed(group.Tag, err); err != nil {
+				return err
+			}
+			continue
+		}
+		s.record(group.Tag, statusSynced, "", digest)
+	}
+
+	return nil
+}
+
+// resolveArchTagGroup returns the digest of every member of a group, so
+// that the verified manifests are the assembled ones even if members move
+// upstream meanwhile.
+func (s *sourceSync) resolveArchTagGroup(group config.ArchTagGroup) (map[string]string, error) {
+	digests := map[string]string{}
+	for member := range group.Members {
+		digest, err := repo.TagDigest(member, s.sourceRepoAddr)
+		if err != nil {
+			return nil, err
+		}
+		digests[member] = digest
+	}
+	return digests, nil
+}
+
+// verifyArchTagGroup checks signatures of the digests of every member of
+// a group.
+func (s *sourceSync) verifyArchTagGroup(group config.ArchTagGroup, digests map[string]string) error {
+	for member := range group.Members {
+		if err := s.verifier.VerifyTag(digests[member], s.sourceRepoAddr); err != nil {
+			return fmt.Errorf("%s : %w", member, err)
+		}
+	}
+	return nil
+}
+
+// rewriteEntries returns the rewrite map entries of the selected tags
+// found in the target, with their target digest.
+func (s *sourceSync) rewriteEntries(dest repo.Destination) rewrite.Map {
+	entries := rewrite.Map{}
+	for _, tag := range s.selectedTags {
+		digest, err := dest.TagDigest(tag, s.targetRepoAddr)
+		if err != nil {
+			log.Debugf("%s : %s not mapped : %s", s.sourceRepoAddr, tag, err)
+			continue
+		}
+		entries = append(entries, rewrite.Entry{Source: s.sourceRepoAddr, Target: s.targetRepoAddr, Tag: tag, Digest: digest})
+	}
+	return entries
+}
+
+func stringInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
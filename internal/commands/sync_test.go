@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestListPlatformTags(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	addenda := []mutate.IndexAddendum{}
+	for _, platform := range []v1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v6"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+	} {
+		platform := platform
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addenda = append(addenda, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &platform}})
+	}
+	ref, err := name.ParseReference(host + "/app:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Push(ref, mutate.AppendManifests(empty.Index, addenda...)); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name    string
+		format  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"variant", "{{.Tag}}-{{.Arch}}{{.Variant}}", map[string]string{"linux/amd64": "1.0-amd64", "linux/arm/v6": "1.0-armv6", "linux/arm/v7": "1.0-armv7"}, false},
+		{"duplicate", "{{.Tag}}-{{.Arch}}", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := config.Source{Source: config.Repo{Host: host, Repository: "app"}, PlatformTagFormat: test.format}
+			got, err := listPlatformTags(source, []string{"1.0"}, nil)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if len(got["1.0"]) != len(test.want) {
+				t.Errorf("got %v, want %v", got["1.0"], test.want)
+			}
+			for platform, tag := range test.want {
+				if got["1.0"][platform] != tag {
+					t.Errorf("got %s for %s, want %s", got["1.0"][platform], platform, tag)
+				}
+			}
+		})
+	}
+}
+
+func TestMissingPlatformTags(t *testing.T) {
+	platformTags := map[string]map[string]string{
+		"1.0": {"linux/amd64": "1.0-amd64", "linux/arm64": "1.0-arm64"},
+		"2.0": {"linux/amd64": "2.0-amd64"},
+	}
+	got := missingPlatformTags(platformTags, []string{"1.0", "2.0", "3.0"}, []string{"1.0", "1.0-amd64", "2.0", "2.0-amd64"})
+	if len(got) != 1 || got[0] != "1.0" {
+		t.Errorf("got %v, want 1.0 missing 1.0-arm64", got)
+	}
+}
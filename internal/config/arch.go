@@ -0,0 +1,188 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// platformTagData holds the fields available in "platformTagFormat".
+type platformTagData struct {
+	Tag     string
+	OS      string
+	Arch    string
+	Variant string
+}
+
+// ArchTagGroup is a set of single-arch source tags that will be assembled
+// into a multi-arch image pushed as Tag on the target.
+// Members maps each source tag to its platform. An empty platform means
+// it must be read from the image itself (unsuffixed tag).
+type ArchTagGroup struct {
+	Tag     string
+	Members map[string]string
+}
+
+// AssembleArchTags reports whether arch-suffixed tags of the source
+// must be grouped into multi-arch images.
+func (s *Source) AssembleArchTags() bool {
+	return len(s.ArchSuffixes) > 0
+}
+
+// sortedArchSuffixes returns configured suffixes, longest first, so that
+// "-arm64" is matched before "-arm".
+func (s *Source) sortedArchSuffixes() []string {
+	suffixes := []string{}
+	for suffix := range s.ArchSuffixes {
+		if suffix == "" {
+			continue
+		}
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Slice(suffixes, func(i, j int) bool {
+		if len(suffixes[i]) != len(suffixes[j]) {
+			return len(suffixes[i]) > len(suffixes[j])
+		}
+		return suffixes[i] < suffixes[j]
+	})
+	return suffixes
+}
+
+func (s *Source) splitArchTag(tag string) (string, string) {
+	for _, suffix := range s.sortedArchSuffixes() {
+		if strings.HasSuffix(tag, suffix) && len(tag) > len(suffix) {
+			return strings.TrimSuffix(tag, suffix), s.ArchSuffixes[suffix]
+		}
+	}
+	return tag, ""
+}
+
+// ExpandArchTags adds to tags every arch-suffixed sibling found in
+// available tags. It is used to complete mutable tags before grouping.
+// An unsuffixed tag only having suffixed siblings upstream is dropped.
+func (s *Source) ExpandArchTags(tags []string, available []string) []string {
+	expandedTags := []string{}
+	for _, tag := range tags {
+		siblings := []string{}
+		for _, suffix := range s.sortedArchSuffixes() {
+			if stringInSlice(tag+suffix, available) && !stringInSlice(tag+suffix, expandedTags) {
+				siblings = append(siblings, tag+suffix)
+			}
+		}
+		// keep the tag itself when it exists or when nothing else does
+		if (stringInSlice(tag, available) || len(siblings) == 0) && !stringInSlice(tag, expandedTags) {
+			expandedTags = append(expandedTags, tag)
+		}
+		expandedTags = append(expandedTags, siblings...)
+	}
+	return expandedTags
+}
+
+// GroupArchTags groups tags by their unsuffixed name.
+// Groups are sorted by target tag.
+func (s *Source) GroupArchTags(tags []string) []ArchTagGroup {
+	groups := map[string]map[string]string{}
+	for _, tag := range tags {
+		if tag == "" {
+			continue
+		}
+		base, platform := s.splitArchTag(tag)
+		if _, ok := groups[base]; !ok {
+			groups[base] = map[string]string{}
+		}
+		groups[base][tag] = platform
+	}
+
+	archTagGroups := []ArchTagGroup{}
+	for base, members := range groups {
+		archTagGroups = append(archTagGroups, ArchTagGroup{Tag: base, Members: members})
+	}
+	sort.Slice(archTagGroups, func(i, j int) bool {
+		return archTagGroups[i].Tag < archTagGroups[j].Tag
+	})
+	return archTagGroups
+}
+
+// SplitPlatformTags reports whether each platform of a multi-arch source
+// tag must also be pushed under its own derived tag.
+func (s *Source) SplitPlatformTags() bool {
+	return s.PlatformTagFormat != ""
+}
+
+// PlatformTag renders the derived tag of a platform ("os/arch[/variant]")
+// image from a source tag, using the "platformTagFormat" template.
+// e.g. "{{.Tag}}-{{.Arch}}{{.Variant}}" gives "1.0.0-armv7".
+func (s *Source) PlatformTag(tag string, platform string) (string, error) {
+	tmpl, err := template.New("platformTag").Option("missingkey=error").Parse(s.PlatformTagFormat)
+	if err != nil {
+		return "", fmt.Errorf("parsing platformTagFormat %w", err)
+	}
+
+	parts := strings.SplitN(platform, "/", 3)
+	data := platformTagData{Tag: tag, OS: parts[0]}
+	if len(parts) > 1 {
+		data.Arch = parts[1]
+	}
+	if len(parts) > 2 {
+		data.Variant = parts[2]
+	}
+
+	var derivedTag bytes.Buffer
+	if err := tmpl.Execute(&derivedTag, data); err != nil {
+		return "", fmt.Errorf("rendering platformTagFormat %w", err)
+	}
+	if derivedTag.String() == tag {
+		return "", fmt.Errorf("platformTagFormat must not render the source tag \"%s\"", tag)
+	}
+
+	return derivedTag.String(), nil
+}
+
+// MissingPlatformTags returns the tags, already in the target, whose
+// derived platform tags are not all in targetTags. With platforms set,
+// the derived tag of each of them is expected. Otherwise the platforms of
+// a tag are only known upstream, and its derived tags are missing when
+// none of them is in targetTags.
+func (s *Source) MissingPlatformTags(tags []string, targetTags []string) ([]string, error) {
+	missing := []string{}
+	for _, tag := range tags {
+		if len(s.Platforms) > 0 {
+			for _, platform := range s.Platforms {
+				derivedTag, err := s.PlatformTag(tag, platform)
+				if err != nil {
+					return nil, err
+				}
+				if !stringInSlice(derivedTag, targetTags) {
+					missing = append(missing, tag)
+					break
+				}
+			}
+			continue
+		}
+
+		// Render the format with placeholders to match any platform.
+		pattern, err := s.PlatformTag(tag, "\x00/\x01/\x02")
+		if err != nil {
+			return nil, err
+		}
+		pattern = regexp.QuoteMeta(pattern)
+		for _, placeholder := range []string{"\x00", "\x01", "\x02"} {
+			pattern = strings.ReplaceAll(pattern, placeholder, ".*")
+		}
+		re := regexp.MustCompile("^" + pattern + "$")
+		found := false
+		for _, targetTag := range targetTags {
+			if targetTag != tag && re.MatchString(targetTag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, tag)
+		}
+	}
+	return missing, nil
+}
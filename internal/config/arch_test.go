@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestGroupArchTags(t *testing.T) {
+	testSource := Source{
+		ArchSuffixes: map[string]string{
+			"-arm":   "linux/arm/v6",
+			"-arm64": "linux/arm64",
+			"-amd64": "linux/amd64",
+		},
+	}
+
+	var tests = []struct {
+		tags []string
+		want []ArchTagGroup
+	}{
+		{[]string{}, []ArchTagGroup{}},
+		{[]string{""}, []ArchTagGroup{}},
+		{
+			[]string{"2.2.0"},
+			[]ArchTagGroup{{"2.2.0", map[string]string{"2.2.0": ""}}},
+		},
+		{
+			[]string{"2.2.0", "2.2.0-arm64"},
+			[]ArchTagGroup{{"2.2.0", map[string]string{"2.2.0": "", "2.2.0-arm64": "linux/arm64"}}},
+		},
+		{
+			[]string{"2.2.0-arm", "2.2.0-amd64", "1.0-arm64"},
+			[]ArchTagGroup{
+				{"1.0", map[string]string{"1.0-arm64": "linux/arm64"}},
+				{"2.2.0", map[string]string{"2.2.0-arm": "linux/arm/v6", "2.2.0-amd64": "linux/amd64"}},
+			},
+		},
+		{
+			[]string{"-arm64"},
+			[]ArchTagGroup{{"-arm64", map[string]string{"-arm64": ""}}},
+		},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("GroupArchTags %v", test.tags)
+		t.Run(testname, func(t *testing.T) {
+			ans := testSource.GroupArchTags(test.tags)
+			if !reflect.DeepEqual(ans, test.want) {
+				t.Errorf("got '%v', want '%v'", ans, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandArchTags(t *testing.T) {
+	testSource := Source{
+		ArchSuffixes: map[string]string{
+			"-arm64": "linux/arm64",
+			"-amd64": "linux/amd64",
+		},
+	}
+	available := []string{"latest", "latest-arm64", "edge-arm64", "edge-amd64"}
+
+	var tests = []struct {
+		tags []string
+		want []string
+	}{
+		{[]string{}, []string{}},
+		{[]string{"latest"}, []string{"latest", "latest-arm64"}},
+		{[]string{"edge"}, []string{"edge-amd64", "edge-arm64"}},
+		{[]string{"missing"}, []string{"missing"}},
+		{[]string{"latest", "latest"}, []string{"latest", "latest-arm64"}},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("ExpandArchTags %v", test.tags)
+		t.Run(testname, func(t *testing.T) {
+			ans := testSource.ExpandArchTags(test.tags, available)
+			if !reflect.DeepEqual(ans, test.want) {
+				t.Errorf("got '%s', want '%s'", ans, test.want)
+			}
+		})
+	}
+}
+
+func TestPlatformTag(t *testing.T) {
+	var tests = []struct {
+		format    string
+		platform  string
+		want      string
+		wantError bool
+	}{
+		{"{{.Tag}}-{{.Arch}}", "linux/amd64", "1.0.0-amd64", false},
+		{"{{.Tag}}-{{.Arch}}{{.Variant}}", "linux/arm/v7", "1.0.0-armv7", false},
+		{"{{.Tag}}-{{.OS}}-{{.Arch}}", "windows/amd64", "1.0.0-windows-amd64", false},
+		{"{{.Tag}}", "linux/amd64", "", true},
+		{"{{.Tag}-{{.Arch}}", "linux/amd64", "", true},
+		{"{{.Tag}}-{{.Unknown}}", "linux/amd64", "", true},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("PlatformTag %s %s", test.format, test.platform)
+		t.Run(testname, func(t *testing.T) {
+			testSource := Source{PlatformTagFormat: test.format}
+			ans, err := testSource.PlatformTag("1.0.0", test.platform)
+			if err != nil && !test.wantError {
+				t.Errorf("got unexpected error %v", err)
+			}
+			if err == nil && test.wantError {
+				t.Errorf("Error is expected, func returned nil")
+			}
+			if ans != test.want {
+				t.Errorf("got '%s', want '%s'", ans, test.want)
+			}
+		})
+	}
+}
+
+func TestMissingPlatformTags(t *testing.T) {
+	targetTags := []string{"1.0", "1.0-linux-amd64", "1.0-linux-arm64", "2.0", "2.0-linux-amd64", "3.0"}
+	var tests = []struct {
+		name      string
+		platforms []string
+		want      []string
+	}{
+		{"configured platforms", []string{"linux/amd64", "linux/arm64"}, []string{"2.0", "3.0"}},
+		{"upstream platforms", nil, []string{"3.0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := Source{PlatformTagFormat: "{{.Tag}}-{{.OS}}-{{.Arch}}{{.Variant}}", Platforms: test.platforms}
+			ans, err := source.MissingPlatformTags([]string{"1.0", "2.0", "3.0"}, targetTags)
+			if err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(ans, test.want) {
+				t.Errorf("got %v, want %v", ans, test.want)
+			}
+		})
+	}
+}
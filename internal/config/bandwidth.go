@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1000,
+	"KB":  1000,
+	"KiB": 1024,
+	"M":   1000 * 1000,
+	"MB":  1000 * 1000,
+	"MiB": 1024 * 1024,
+	"G":   1000 * 1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"GiB": 1024 * 1024 * 1024,
+}
+
+// ParseSize parses a size like "10GiB" to bytes. An empty size is 0.
+func ParseSize(size string) (int64, error) {
+	value := strings.TrimSpace(size)
+	if value == "" {
+		return 0, nil
+	}
+
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(value)
+	}
+	number, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing size \"%s\" : %w", size, err)
+	}
+	unit, ok := sizeUnits[strings.TrimSpace(value[i:])]
+	if !ok {
+		return 0, fmt.Errorf("parsing size \"%s\" : unknown unit \"%s\"", size, value[i:])
+	}
+
+	return int64(number * float64(unit)), nil
+}
+
+// ParseBandwidth parses a bandwidth limit like "50MiB/s" to bytes per
+// second. An empty limit is 0, i.e. unlimited.
+func ParseBandwidth(limit string) (int64, error) {
+	value := strings.TrimSuffix(strings.TrimSpace(limit), "/s")
+	if value == "" {
+		return 0, nil
+	}
+
+	bytesPerSecond, err := ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("parsing bandwidth \"%s\" : %w", limit, err)
+	}
+	if bytesPerSecond <= 0 {
+		return 0, fmt.Errorf("parsing bandwidth \"%s\" : must be positive", limit)
+	}
+	return bytesPerSecond, nil
+}
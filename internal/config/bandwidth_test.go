@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	var tests = []struct {
+		limit   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1024", 1024, false},
+		{"50MiB/s", 50 * 1024 * 1024, false},
+		{"1.5 MB/s", 1500000, false},
+		{"512KiB", 512 * 1024, false},
+		{"2GB/s", 2000000000, false},
+		{"10Mbit/s", 0, true},
+		{"fast", 0, true},
+		{"0MiB/s", 0, true},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("bandwidth \"%s\"", test.limit)
+		t.Run(testname, func(t *testing.T) {
+			ans, err := ParseBandwidth(test.limit)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if ans != test.want {
+				t.Errorf("got %d, want %d", ans, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	var tests = []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"10GiB", 10 << 30, false},
+		{"500 MB", 500000000, false},
+		{"10GiB/s", 0, true},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("size \"%s\"", test.size)
+		t.Run(testname, func(t *testing.T) {
+			ans, err := ParseSize(test.size)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if ans != test.want {
+				t.Errorf("got %d, want %d", ans, test.want)
+			}
+		})
+	}
+}
@@ -14,11 +14,31 @@ const (
 	defaultConfigFilename = ".imgsync.yaml"
 )
 
+// Source and target types.
+const (
+	RepoTypeRegistry      = "registry"
+	RepoTypeOCILayout     = "oci"
+	RepoTypeDockerArchive = "docker-archive"
+)
+
 // Config contains sources and target definition for imgsync job.
 type Config struct {
 	Target              Repo     `yaml:"target"`
 	Sources             []Source `yaml:"sources,omitempty"`
 	ContinueOnSyncError bool     `yaml:"continueOnSyncError,omitempty"`
+	StateFile           string   `yaml:"stateFile,omitempty"`
+	DetectTagMutation   bool     `yaml:"detectTagMutation,omitempty"`
+	FailOnTagMutation   bool     `yaml:"failOnTagMutation,omitempty"`
+	Retry               Retry    `yaml:"retry,omitempty"`
+	RateLimitMaxWait    string   `yaml:"rateLimitMaxWait,omitempty"`
+	// BandwidthLimit applies to all transfers, e.g. "50MiB/s".
+	BandwidthLimit string `yaml:"bandwidthLimit,omitempty"`
+	// CacheDir stores pulled layers between runs, up to CacheMaxSize
+	// (e.g. "10GiB", no limit when empty).
+	CacheDir     string `yaml:"cacheDir,omitempty"`
+	CacheMaxSize string `yaml:"cacheMaxSize,omitempty"`
+	// Schedule is when the daemon syncs sources without their own.
+	Schedule Schedule `yaml:"schedule,omitempty"`
 	// ListTimeout          string   `yaml:"listTimeout,omitempty"`
 	// SyncTimeout          string   `yaml:"syncTimeout,omitempty"`
 	// DeleteUnmanagedTags  bool     `yaml:"deleteUnmanagedTags,omitempty"`
@@ -35,26 +55,60 @@ type Auth struct {
 // the configuration will be pushed to.
 type Repo struct {
 	Repository string `yaml:"repository"`
-	Scheme     string `yaml:"scheme:omitempty"`
-	Host       string `yaml:"host,omitempty"`
-	Auth       Auth   `yaml:"auth,omitempty"`
+	// RepositoryRegex selects source repositories listed from the registry,
+	// like a "*" wildcard in Repository does.
+	RepositoryRegex string `yaml:"repositoryRegex,omitempty"`
+	Scheme          string `yaml:"scheme:omitempty"`
+	Host            string `yaml:"host,omitempty"`
+	Auth            Auth   `yaml:"auth,omitempty"`
+	// RequestsPerMinute throttles requests to the registry host, 0 for no limit.
+	RequestsPerMinute int `yaml:"requestsPerMinute,omitempty"`
+	// BandwidthLimit applies to transfers with the registry host.
+	BandwidthLimit string `yaml:"bandwidthLimit,omitempty"`
+	// Type of a source or target: a registry (default), an OCI image
+	// layout or a docker-archive tarball at Path. Host and repository
+	// still name the references of images on disk.
+	Type string `yaml:"type,omitempty"`
+	Path string `yaml:"path,omitempty"`
 	// AllowInsecure bool `yaml:"allowInsecure,omitempty"`
 }
 
 // Source is a container registry where tags will be pulled from.
 // Images can be selected with multiple options and strategies.
 type Source struct {
-	Source             Repo     `yaml:"source"`
-	Tags               []string `yaml:"tags,omitempty"`
-	MutableTags        []string `yaml:"mutableTags,omitempty"`
-	RegexTags          []string `yaml:"regexTags,omitempty"`
-	LatestSemverSync   bool     `yaml:"latestSemverSync,omitempty"`
-	LatestSemverRegex  string   `yaml:"latestSemverRegex,omitempty"`
-	OmitPreReleaseTags bool     `yaml:"omitPreReleaseTags,omitempty"`
-	OmitDashedTags     bool     `yaml:"omitDashedTags,omitempty"`
+	Source             Repo              `yaml:"source"`
+	Tags               []string          `yaml:"tags,omitempty"`
+	MutableTags        []string          `yaml:"mutableTags,omitempty"`
+	RegexTags          []string          `yaml:"regexTags,omitempty"`
+	LatestSemverSync   bool              `yaml:"latestSemverSync,omitempty"`
+	LatestSemverRegex  string            `yaml:"latestSemverRegex,omitempty"`
+	OmitPreReleaseTags bool              `yaml:"omitPreReleaseTags,omitempty"`
+	OmitDashedTags     bool              `yaml:"omitDashedTags,omitempty"`
+	Platforms          []string          `yaml:"platforms,omitempty"`
+	ArchSuffixes       map[string]string `yaml:"archSuffixes,omitempty"`
+	PlatformTagFormat  string            `yaml:"platformTagFormat,omitempty"`
+	SyncArtifacts      bool              `yaml:"syncArtifacts,omitempty"`
+	Verify             *Verify           `yaml:"verify,omitempty"`
+	// Schedule overrides the daemon schedule for this source.
+	Schedule *Schedule `yaml:"schedule,omitempty"`
+}
+
+// Verify defines how cosign signatures of source tags are checked
+// before being synced. Either a public key, or a keyless certificate
+// identity and issuer with a local trust root must be provided.
+// Keyless verification checks the certificate chain, identity and issuer
+// only: without transparency log or SCT checks, it is not a supply-chain
+// guarantee.
+type Verify struct {
+	Key            string `yaml:"key,omitempty"`
+	CertIdentity   string `yaml:"certIdentity,omitempty"`
+	CertOidcIssuer string `yaml:"certOidcIssuer,omitempty"`
+	TrustRoot      string `yaml:"trustRoot,omitempty"`
 }
 
-func getConfigLocation(path string) string {
+// GetConfigLocation returns the config file path, a directory path holding
+// the default config filename.
+func GetConfigLocation(path string) string {
 	location := path
 	if !strings.Contains(location, ".yaml") && !strings.Contains(location, ".yml") {
 		location = filepath.Join(path, defaultConfigFilename)
@@ -65,7 +119,7 @@ func getConfigLocation(path string) string {
 
 // Get returns the configuration found at the specified path.
 func Get(path string) (Config, error) {
-	configLocation := getConfigLocation(path)
+	configLocation := GetConfigLocation(path)
 	configContents, err := ioutil.ReadFile(configLocation)
 	if err != nil {
 		return Config{}, fmt.Errorf("reading config: %w", err)
@@ -75,10 +129,41 @@ func Get(path string) (Config, error) {
 	if err := yaml.Unmarshal(configContents, &config); err != nil {
 		return Config{}, fmt.Errorf("unmarshal config: %w", err)
 	}
+	if err := config.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
 
 	return config, nil
 }
 
+// validate rejects settings the target can't honor.
+func (c *Config) validate() error {
+	if c.Target.Type != RepoTypeDockerArchive {
+		return nil
+	}
+	// Archives only hold tagged images: artifacts are looked up by digest.
+	for _, source := range c.Sources {
+		if source.SyncArtifacts {
+			return fmt.Errorf("%s : syncArtifacts is not supported with a %s target", source.Source.GetRepositoryAddress(), RepoTypeDockerArchive)
+		}
+	}
+	return nil
+}
+
+// GetType returns the source or target type, a registry by default.
+func (r *Repo) GetType() (string, error) {
+	switch r.Type {
+	case "", RepoTypeRegistry:
+		return RepoTypeRegistry, nil
+	case RepoTypeOCILayout, RepoTypeDockerArchive:
+		if r.Path == "" {
+			return "", fmt.Errorf("%s repository requires a path", r.Type)
+		}
+		return r.Type, nil
+	}
+	return "", fmt.Errorf("unknown repository type \"%s\"", r.Type)
+}
+
 func (r *Repo) supportNestedRepositories() bool {
 	// Quay.io
 	if strings.Contains(r.Host, "quay.io") {
@@ -23,7 +23,7 @@ func TestGetConfigLocation(t *testing.T) {
 	for _, test := range tests {
 		testname := fmt.Sprintf("location \"%s\"", test.location)
 		t.Run(testname, func(t *testing.T) {
-			ans := getConfigLocation(test.location)
+			ans := GetConfigLocation(test.location)
 			if ans != test.want {
 				t.Errorf("got '%s', want '%s'", ans, test.want)
 			}
@@ -77,3 +77,47 @@ func TestGetRepositoryAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestGetType(t *testing.T) {
+	var tests = []struct {
+		repo    Repo
+		want    string
+		wantErr bool
+	}{
+		{Repo{}, RepoTypeRegistry, false},
+		{Repo{Type: "registry"}, RepoTypeRegistry, false},
+		{Repo{Type: "oci", Path: "/backup"}, RepoTypeOCILayout, false},
+		{Repo{Type: "docker-archive", Path: "images.tar"}, RepoTypeDockerArchive, false},
+		{Repo{Type: "oci"}, "", true},
+		{Repo{Type: "s3", Path: "bucket"}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.repo.Type, func(t *testing.T) {
+			ans, err := test.repo.GetType()
+			if ans != test.want || (err != nil) != test.wantErr {
+				t.Errorf("got '%s', %v, want '%s'", ans, err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"registry artifacts", Config{Sources: []Source{{SyncArtifacts: true}}}, false},
+		{"archive", Config{Target: Repo{Type: RepoTypeDockerArchive, Path: "images.tar"}, Sources: []Source{{}}}, false},
+		{"archive artifacts", Config{Target: Repo{Type: RepoTypeDockerArchive, Path: "images.tar"}, Sources: []Source{{SyncArtifacts: true}}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.config.validate(); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
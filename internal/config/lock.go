@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultLockFilename = ".imgsync.lock"
+)
+
+// Lock pins every selected tag of each source to a manifest digest,
+// making syncs reproducible across environments.
+type Lock struct {
+	Sources []LockedSource `yaml:"sources"`
+}
+
+// LockedSource holds the locked tags of a source repository.
+type LockedSource struct {
+	Source string            `yaml:"source"`
+	Tags   map[string]string `yaml:"tags"`
+}
+
+// GetLockLocation returns the lock file path matching a config path.
+// The lock file is stored next to the config file.
+func GetLockLocation(path string) string {
+	return filepath.Join(filepath.Dir(GetConfigLocation(path)), defaultLockFilename)
+}
+
+// GetLock returns the lock file found at the specified path.
+func GetLock(path string) (Lock, error) {
+	lockContents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Lock{}, fmt.Errorf("reading lock : %w", err)
+	}
+
+	var lock Lock
+	if err := yaml.Unmarshal(lockContents, &lock); err != nil {
+		return Lock{}, fmt.Errorf("unmarshal lock : %w", err)
+	}
+
+	return lock, nil
+}
+
+// Save writes the lock file at the specified path.
+func (l *Lock) Save(path string) error {
+	sort.Slice(l.Sources, func(i, j int) bool {
+		return l.Sources[i].Source < l.Sources[j].Source
+	})
+
+	lockContents, err := yaml.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("marshal lock : %w", err)
+	}
+	if err := ioutil.WriteFile(path, lockContents, 0644); err != nil {
+		return fmt.Errorf("writing lock : %w", err)
+	}
+
+	return nil
+}
+
+// LockedTags returns the locked tags and digests of a source repository.
+func (l *Lock) LockedTags(source string) (map[string]string, bool) {
+	for _, lockedSource := range l.Sources {
+		if lockedSource.Source == source {
+			return lockedSource.Tags, true
+		}
+	}
+	return map[string]string{}, false
+}
+
+// SetLockedTags records the locked tags and digests of a source repository.
+func (l *Lock) SetLockedTags(source string, tags map[string]string) {
+	for i, lockedSource := range l.Sources {
+		if lockedSource.Source == source {
+			l.Sources[i].Tags = tags
+			return
+		}
+	}
+	l.Sources = append(l.Sources, LockedSource{Source: source, Tags: tags})
+}
+
+// splitTagPin splits a "tag@sha256:..." entry into its tag and digest.
+func splitTagPin(entry string) (string, string) {
+	parts := strings.SplitN(entry, "@", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return entry, ""
+}
+
+// TagPins returns the digests pinned in "tags" entries ("1.2.3@sha256:...").
+func (s *Source) TagPins() map[string]string {
+	pins := map[string]string{}
+	for _, entry := range s.Tags {
+		tag, digest := splitTagPin(entry)
+		if digest != "" {
+			pins[tag] = digest
+		}
+	}
+	return pins
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testDigest = "sha256:da9a0872ad0512636576de77b29a12b5a36a5f78c89b420b453ac8f99747f9f3"
+
+func TestTagPins(t *testing.T) {
+	var tests = []struct {
+		tags []string
+		want map[string]string
+	}{
+		{[]string{}, map[string]string{}},
+		{[]string{"1.0.0", "latest"}, map[string]string{}},
+		{[]string{"1.0.0@" + testDigest, "1.1.0"}, map[string]string{"1.0.0": testDigest}},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("TagPins %v", test.tags)
+		t.Run(testname, func(t *testing.T) {
+			testSource := Source{Tags: test.tags}
+			ans := testSource.TagPins()
+			if !reflect.DeepEqual(ans, test.want) {
+				t.Errorf("got '%v', want '%v'", ans, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchingPinnedTags(t *testing.T) {
+	testSource := Source{Tags: []string{"1.0.0@" + testDigest, "1.1.0"}}
+	want := []string{"1.0.0", "1.1.0"}
+
+	ans := testSource.matchingTags([]string{"latest", "1.0.0", "1.1.0"})
+	if !reflect.DeepEqual(ans, want) {
+		t.Errorf("got '%s', want '%s'", ans, want)
+	}
+}
+
+func TestGetLockLocation(t *testing.T) {
+	var tests = []struct {
+		location string
+		want     string
+	}{
+		{"", ".imgsync.lock"},
+		{"./", ".imgsync.lock"},
+		{"test", "test/.imgsync.lock"},
+		{"test/config.yaml", "test/.imgsync.lock"},
+		{"/etc/imgsync.yml", "/etc/.imgsync.lock"},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("location \"%s\"", test.location)
+		t.Run(testname, func(t *testing.T) {
+			ans := GetLockLocation(test.location)
+			if ans != test.want {
+				t.Errorf("got '%s', want '%s'", ans, test.want)
+			}
+		})
+	}
+}
+
+func TestLockSaveAndGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultLockFilename)
+
+	lock := Lock{}
+	lock.SetLockedTags("index.docker.io/library/nginx", map[string]string{"1.19.0": testDigest})
+	lock.SetLockedTags("index.docker.io/barthv/uhubctl", map[string]string{"2.2.0": testDigest})
+	lock.SetLockedTags("index.docker.io/library/nginx", map[string]string{"1.19.1": testDigest})
+	if err := lock.Save(path); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+
+	loaded, err := GetLock(path)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if len(loaded.Sources) != 2 {
+		t.Errorf("got %d locked sources, want 2", len(loaded.Sources))
+	}
+	tags, ok := loaded.LockedTags("index.docker.io/library/nginx")
+	if !ok || !reflect.DeepEqual(tags, map[string]string{"1.19.1": testDigest}) {
+		t.Errorf("got '%v', want nginx 1.19.1 locked", tags)
+	}
+	if _, ok := loaded.LockedTags("index.docker.io/library/redis"); ok {
+		t.Errorf("redis must not be locked")
+	}
+}
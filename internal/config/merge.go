@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MergeSources merges sources into the config contents: tags and mutable
+// tags not already selected are added to the source of the same
+// repository, or a new source is appended. Tags selected by a pattern
+// source are skipped. Other settings are kept as-is, comments are not. It
+// returns the merged contents and the number of tags added.
+func MergeSources(contents []byte, sources []Source) ([]byte, int, error) {
+	document := yaml.MapSlice{}
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		return nil, 0, fmt.Errorf("unmarshal config : %w", err)
+	}
+
+	var items []interface{}
+	sourcesIndex := -1
+	for i, item := range document {
+		if item.Key == "sources" {
+			sourcesIndex = i
+			items, _ = item.Value.([]interface{})
+		}
+	}
+
+	// Decode existing sources through yaml to match them.
+	existing := make([]Source, len(items))
+	for i, item := range items {
+		raw, err := yaml.Marshal(item)
+		if err != nil {
+			return nil, 0, fmt.Errorf("marshal source : %w", err)
+		}
+		if err := yaml.Unmarshal(raw, &existing[i]); err != nil {
+			return nil, 0, fmt.Errorf("unmarshal source : %w", err)
+		}
+	}
+
+	// Tags selected by a pattern source are synced already.
+	patterns := Config{}
+	for _, source := range existing {
+		if source.IsRepositoryPattern() {
+			patterns.Sources = append(patterns.Sources, source)
+		}
+	}
+
+	added := 0
+	for _, source := range sources {
+		match, ok, err := patterns.MatchSource(source.repositoryName())
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			if source.Tags, err = unselectedTags(match, source.Tags); err != nil {
+				return nil, 0, err
+			}
+			if source.MutableTags, err = unselectedTags(match, source.MutableTags); err != nil {
+				return nil, 0, err
+			}
+			if len(source.Tags) == 0 && len(source.MutableTags) == 0 {
+				continue
+			}
+		}
+
+		found := -1
+		for i := range existing {
+			if existing[i].repositoryName() != "" && existing[i].repositoryName() == source.repositoryName() {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			repo := yaml.MapSlice{}
+			if source.Source.Host != "" {
+				repo = append(repo, yaml.MapItem{Key: "host", Value: source.Source.Host})
+			}
+			repo = append(repo, yaml.MapItem{Key: "repository", Value: source.Source.Repository})
+			item := yaml.MapSlice{{Key: "source", Value: repo}}
+			if len(source.Tags) > 0 {
+				item = append(item, yaml.MapItem{Key: "tags", Value: source.Tags})
+			}
+			if len(source.MutableTags) > 0 {
+				item = append(item, yaml.MapItem{Key: "mutableTags", Value: source.MutableTags})
+			}
+			if len(source.Platforms) > 0 {
+				item = append(item, yaml.MapItem{Key: "platforms", Value: source.Platforms})
+			}
+			items = append(items, item)
+			existing = append(existing, source)
+			added += len(source.Tags) + len(source.MutableTags)
+			continue
+		}
+
+		item, ok := items[found].(yaml.MapSlice)
+		if !ok {
+			return nil, 0, fmt.Errorf("%s : unexpected source format", source.Source.GetRepositoryAddress())
+		}
+		tags, err := unselectedTags(existing[found], source.Tags)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, entry := range tags {
+			item = appendTag(item, "tags", entry)
+			existing[found].Tags = append(existing[found].Tags, entry)
+		}
+		mutableTags, err := unselectedTags(existing[found], source.MutableTags)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, tag := range mutableTags {
+			item = appendTag(item, "mutableTags", tag)
+			existing[found].MutableTags = append(existing[found].MutableTags, tag)
+		}
+		added += len(tags) + len(mutableTags)
+		items[found] = item
+	}
+
+	if sourcesIndex < 0 {
+		document = append(document, yaml.MapItem{Key: "sources", Value: items})
+	} else {
+		document[sourcesIndex].Value = items
+	}
+	merged, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, 0, fmt.Errorf("marshal config : %w", err)
+	}
+	return merged, added, nil
+}
+
+// unselectedTags returns the entries, possibly pinned, whose tag the
+// source does not select yet.
+func unselectedTags(source Source, entries []string) ([]string, error) {
+	tags := []string{}
+	for _, entry := range entries {
+		tag, _ := splitTagPin(entry)
+		selected, err := source.SelectsTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !selected && !stringInSlice(entry, tags) {
+			tags = append(tags, entry)
+		}
+	}
+	return tags, nil
+}
+
+// appendTag adds an entry to the key ("tags" or "mutableTags") of a source.
+func appendTag(item yaml.MapSlice, key string, tag string) yaml.MapSlice {
+	for i := range item {
+		if item[i].Key == key {
+			tags, _ := item[i].Value.([]interface{})
+			item[i].Value = append(tags, tag)
+			return item
+		}
+	}
+	return append(item, yaml.MapItem{Key: key, Value: []interface{}{tag}})
+}
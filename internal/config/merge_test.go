@@ -0,0 +1,64 @@
+package config
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMergeSources(t *testing.T) {
+	contents := []byte(`target:
+  host: 127.0.0.1:5000
+sources:
+- source:
+    repository: nginx
+    host: docker.io
+  regexTags:
+  - "^1\\.24"
+  platforms:
+  - linux/amd64
+- source:
+    repository: "bitnami/*"
+  regexTags:
+  - "^7\\."
+`)
+	sources := []Source{
+		{Source: Repo{Repository: "nginx"}, Tags: []string{"1.24.0", "1.25"}, MutableTags: []string{"latest"}},
+		{Source: Repo{Repository: "library/nginx"}, Tags: []string{"1.25"}},
+		{Source: Repo{Host: "quay.io", Repository: "team/app"}, Tags: []string{"v1"}},
+		{Source: Repo{Repository: "bitnami/redis"}, Tags: []string{"7.2", "6.2"}},
+	}
+
+	merged, added, err := MergeSources(contents, sources)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if added != 4 {
+		t.Errorf("got %d tags added, want 4", added)
+	}
+
+	var conf Config
+	if err := yaml.Unmarshal(merged, &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Target.Host != "127.0.0.1:5000" || len(conf.Sources) != 4 {
+		t.Fatalf("got config %+v", conf)
+	}
+	nginx := conf.Sources[0]
+	if len(nginx.Tags) != 1 || nginx.Tags[0] != "1.25" || len(nginx.MutableTags) != 1 || len(nginx.Platforms) != 1 {
+		t.Errorf("got nginx source %+v, want 1.25 and mutable latest added and settings kept", nginx)
+	}
+	if app := conf.Sources[2]; app.Source.GetRepositoryAddress() != "quay.io/team/app" || len(app.Tags) != 1 {
+		t.Errorf("got new source %+v", app)
+	}
+	if redis := conf.Sources[3]; redis.Source.Repository != "bitnami/redis" || len(redis.Tags) != 1 || redis.Tags[0] != "6.2" {
+		t.Errorf("got source %+v, want only tags bitnami/* does not select", redis)
+	}
+
+	if _, added, _ := MergeSources(merged, sources); added != 0 {
+		t.Errorf("got %d tags added merging twice, want 0", added)
+	}
+	if merged, _, err := MergeSources(nil, sources[2:3]); err != nil || len(merged) == 0 {
+		t.Errorf("got %v merging into an empty config", err)
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// IsRepositoryPattern returns whether a source selects every repository
+// of its registry matching a "*" wildcard or a regex.
+func (s *Source) IsRepositoryPattern() bool {
+	return s.Source.RepositoryRegex != "" || strings.Contains(s.Source.Repository, "*")
+}
+
+// RepositoryNamespace returns the namespace shared by every repository
+// a pattern may match ("bitnami" for "bitnami/*"), "" when unknown.
+func (s *Source) RepositoryNamespace() string {
+	prefix := s.Source.Repository
+	if s.Source.RepositoryRegex != "" {
+		// Only anchored regexes have a namespace. Prefixes are not computed
+		// for anchored regexes, so compute it without the anchor.
+		if !strings.HasPrefix(s.Source.RepositoryRegex, "^") {
+			return ""
+		}
+		re, err := regexp.Compile(strings.TrimPrefix(s.Source.RepositoryRegex, "^"))
+		if err != nil {
+			return ""
+		}
+		prefix, _ = re.LiteralPrefix()
+	}
+	prefix = strings.SplitN(prefix, "*", 2)[0]
+
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		return prefix[:i]
+	}
+	return ""
+}
+
+// ExpandRepositories returns a source for each repository matching the
+// pattern of s, with the tag selectors of s.
+func (s *Source) ExpandRepositories(repositories []string) ([]Source, error) {
+	var re *regexp.Regexp
+	if s.Source.RepositoryRegex != "" {
+		var err error
+		re, err = regexp.Compile(s.Source.RepositoryRegex)
+		if err != nil {
+			return nil, fmt.Errorf("parsing repositoryRegex : %w", err)
+		}
+	}
+
+	matching := []string{}
+	for _, repository := range repositories {
+		if re != nil {
+			if re.MatchString(repository) {
+				matching = append(matching, repository)
+			}
+			continue
+		}
+		ok, err := path.Match(s.Source.Repository, repository)
+		if err != nil {
+			return nil, fmt.Errorf("parsing repository pattern : %w", err)
+		}
+		if ok {
+			matching = append(matching, repository)
+		}
+	}
+	sort.Strings(matching)
+
+	sources := []Source{}
+	for _, repository := range matching {
+		source := *s
+		source.Source.Repository = repository
+		source.Source.RepositoryRegex = ""
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// repositoryName returns the normalized name of a source repository,
+// "" for sources not read from a single registry repository.
+func (s *Source) repositoryName() string {
+	if s.IsRepositoryPattern() || (s.Source.Type != "" && s.Source.Type != RepoTypeRegistry) {
+		return ""
+	}
+	repository, err := name.NewRepository(s.Source.GetRepositoryAddress())
+	if err != nil {
+		return ""
+	}
+	return repository.Name()
+}
+
+// SelectsTag returns whether a source selects a tag by name or regex.
+func (s *Source) SelectsTag(tag string) (bool, error) {
+	if len(s.matchingTags([]string{tag})) > 0 || stringInSlice(tag, s.MutableTags) {
+		return true, nil
+	}
+	matching, err := s.matchingRegexTags([]string{tag})
+	return len(matching) > 0, err
+}
+
+// MatchSource returns the source of a repository name, with its pattern
+// expanded to the repository.
+func (c *Config) MatchSource(repository string) (Source, bool, error) {
+	ref, err := name.NewRepository(repository)
+	if err != nil {
+		return Source{}, false, err
+	}
+	for _, source := range c.Sources {
+		if !source.IsRepositoryPattern() {
+			if source.repositoryName() == ref.Name() {
+				return source, true, nil
+			}
+			continue
+		}
+		host, err := name.NewRegistry(source.Source.Host)
+		if err != nil || host.RegistryStr() != ref.RegistryStr() {
+			continue
+		}
+		expanded, err := source.ExpandRepositories([]string{ref.RepositoryStr()})
+		if err != nil {
+			return Source{}, false, err
+		}
+		if len(expanded) > 0 {
+			return expanded[0], true, nil
+		}
+	}
+	return Source{}, false, nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRepositoryNamespace(t *testing.T) {
+	var tests = []struct {
+		repo Repo
+		want string
+	}{
+		{Repo{Repository: "bitnami/*"}, "bitnami"},
+		{Repo{Repository: "team/apps/web-*"}, "team/apps"},
+		{Repo{Repository: "*/nginx"}, ""},
+		{Repo{RepositoryRegex: "^bitnami/.*-exporter$"}, "bitnami"},
+		{Repo{RepositoryRegex: "bitnami/.*"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.repo.Repository+test.repo.RepositoryRegex, func(t *testing.T) {
+			source := Source{Source: test.repo}
+			if !source.IsRepositoryPattern() {
+				t.Errorf("got no repository pattern")
+			}
+			if ans := source.RepositoryNamespace(); ans != test.want {
+				t.Errorf("got '%s', want '%s'", ans, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandRepositories(t *testing.T) {
+	repositories := []string{"bitnami/redis", "bitnami/nginx", "bitnami/charts/nginx", "library/nginx"}
+
+	var tests = []struct {
+		repo Repo
+		want []string
+	}{
+		{Repo{Repository: "bitnami/*"}, []string{"bitnami/nginx", "bitnami/redis"}},
+		{Repo{Repository: "*/nginx"}, []string{"bitnami/nginx", "library/nginx"}},
+		{Repo{RepositoryRegex: "^bitnami/.*nginx$"}, []string{"bitnami/charts/nginx", "bitnami/nginx"}},
+		{Repo{Repository: "quay/*"}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.repo.Repository+test.repo.RepositoryRegex, func(t *testing.T) {
+			source := Source{Source: test.repo, Tags: []string{"1.0.0"}}
+			sources, err := source.ExpandRepositories(repositories)
+			if err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			ans := []string{}
+			for _, s := range sources {
+				if s.IsRepositoryPattern() || !reflect.DeepEqual(s.Tags, source.Tags) {
+					t.Errorf("got source %v, want tag selectors of the pattern", s)
+				}
+				ans = append(ans, s.Source.Repository)
+			}
+			if !reflect.DeepEqual(ans, test.want) {
+				t.Errorf("got %v, want %v", ans, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultRetryAttempts     = 3
+	defaultRetryInitialDelay = time.Second
+	defaultRetryMaxDelay     = 30 * time.Second
+	defaultRateLimitMaxWait  = 5 * time.Minute
+)
+
+// Retry defines how failed registry operations are retried, with an
+// exponential backoff between attempts. Delays are Go durations ("2s").
+type Retry struct {
+	Attempts     int    `yaml:"attempts,omitempty"`
+	InitialDelay string `yaml:"initialDelay,omitempty"`
+	MaxDelay     string `yaml:"maxDelay,omitempty"`
+}
+
+// GetAttempts returns the maximum number of attempts of an operation.
+func (r *Retry) GetAttempts() int {
+	if r.Attempts <= 0 {
+		return defaultRetryAttempts
+	}
+	return r.Attempts
+}
+
+// GetDelays returns the delay before the first retry and the maximum delay
+// between two attempts.
+func (r *Retry) GetDelays() (time.Duration, time.Duration, error) {
+	initial, err := parseDuration(r.InitialDelay, defaultRetryInitialDelay)
+	if err != nil {
+		return 0, 0, fmt.Errorf("retry initialDelay : %w", err)
+	}
+	max, err := parseDuration(r.MaxDelay, defaultRetryMaxDelay)
+	if err != nil {
+		return 0, 0, fmt.Errorf("retry maxDelay : %w", err)
+	}
+	if max < initial {
+		max = initial
+	}
+	return initial, max, nil
+}
+
+// GetRateLimitMaxWait returns how long a sync may be paused for a
+// registry rate limit to reset.
+func (c *Config) GetRateLimitMaxWait() (time.Duration, error) {
+	wait, err := parseDuration(c.RateLimitMaxWait, defaultRateLimitMaxWait)
+	if err != nil {
+		return 0, fmt.Errorf("rateLimitMaxWait : %w", err)
+	}
+	return wait, nil
+}
+
+func parseDuration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return time.ParseDuration(value)
+}
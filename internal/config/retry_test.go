@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryGetDelays(t *testing.T) {
+	var tests = []struct {
+		retry   Retry
+		initial time.Duration
+		max     time.Duration
+		wantErr bool
+	}{
+		{Retry{}, time.Second, 30 * time.Second, false},
+		{Retry{InitialDelay: "500ms", MaxDelay: "1m"}, 500 * time.Millisecond, time.Minute, false},
+		{Retry{InitialDelay: "10s", MaxDelay: "1s"}, 10 * time.Second, 10 * time.Second, false},
+		{Retry{InitialDelay: "soon"}, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("%+v", test.retry)
+		t.Run(testname, func(t *testing.T) {
+			initial, max, err := test.retry.GetDelays()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if initial != test.initial || max != test.max {
+				t.Errorf("got %s/%s, want %s/%s", initial, max, test.initial, test.max)
+			}
+		})
+	}
+}
+
+func TestRetryGetAttempts(t *testing.T) {
+	if ans := (&Retry{}).GetAttempts(); ans != defaultRetryAttempts {
+		t.Errorf("got %d, want default %d", ans, defaultRetryAttempts)
+	}
+	if ans := (&Retry{Attempts: 1}).GetAttempts(); ans != 1 {
+		t.Errorf("got %d, want 1", ans)
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultScheduleInterval = time.Hour
+)
+
+// Schedule defines when the daemon syncs: every interval (a Go duration)
+// or at the times of a cron expression ("0 */6 * * *"), delayed by a
+// random jitter up to Jitter.
+type Schedule struct {
+	Interval string `yaml:"interval,omitempty"`
+	Cron     string `yaml:"cron,omitempty"`
+	Jitter   string `yaml:"jitter,omitempty"`
+}
+
+// IsSet returns whether a schedule is configured.
+func (s *Schedule) IsSet() bool {
+	return s != nil && (s.Interval != "" || s.Cron != "")
+}
+
+// Next returns the next sync time after now, jitter included. Schedules
+// neither set run every hour.
+func (s *Schedule) Next(now time.Time) (time.Time, error) {
+	if s.Interval != "" && s.Cron != "" {
+		return time.Time{}, fmt.Errorf("schedule : interval and cron are exclusive")
+	}
+	jitter, err := parseDuration(s.Jitter, 0)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("schedule jitter : %w", err)
+	}
+	if jitter > 0 {
+		jitter = time.Duration(rand.Int63n(int64(jitter)))
+	}
+
+	if s.Cron != "" {
+		cron, err := ParseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("schedule cron : %w", err)
+		}
+		next := cron.Next(now)
+		if next.IsZero() {
+			return time.Time{}, fmt.Errorf("schedule cron : \"%s\" never matches", s.Cron)
+		}
+		return next.Add(jitter), nil
+	}
+	interval, err := parseDuration(s.Interval, defaultScheduleInterval)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("schedule interval : %w", err)
+	}
+	if interval <= 0 {
+		return time.Time{}, fmt.Errorf("schedule interval must be positive")
+	}
+	return now.Add(interval).Add(jitter), nil
+}
+
+// Cron is a parsed cron expression: minute, hour, day of month, month and
+// day of week, each field being *, a value, a range, a list or a step.
+type Cron struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	// anyDay and anyWeekday tell whether the day fields were "*", as days
+	// match either field when both are restricted.
+	anyDay, anyWeekday bool
+}
+
+// ParseCron parses a 5 fields cron expression.
+func ParseCron(expression string) (*Cron, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("\"%s\" : expected 5 fields", expression)
+	}
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := make([]map[int]bool, 5)
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("\"%s\" : %w", expression, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7.
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &Cron{
+		minutes:    sets[0],
+		hours:      sets[1],
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   sets[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step \"%s\"", part)
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value \"%s\"", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid value \"%s\"", part)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("\"%s\" out of range %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (c *Cron) matchesDay(t time.Time) bool {
+	day, weekday := c.days[t.Day()], c.weekdays[int(t.Weekday())]
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// Next returns the first time matching the expression after t, or the
+// zero time when none matches within five years.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !c.months[int(t.Month())] || !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minutes[t.Minute()] {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
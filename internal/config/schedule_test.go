@@ -0,0 +1,75 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 22, 30, 15, 0, time.UTC) // Wednesday
+
+	var tests = []struct {
+		expression string
+		want       time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 31, 22, 31, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"15,45 22 * * *", time.Date(2024, time.January, 31, 22, 45, 0, 0, time.UTC)},
+		{"0 3 * * 0", time.Date(2024, time.February, 4, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2024, time.February, 4, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 1-5", time.Date(2024, time.February, 1, 3, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC)},
+		{"30/10 8 1 * *", time.Date(2024, time.February, 1, 8, 30, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			cron, err := ParseCron(test.expression)
+			if err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			if ans := cron.Next(from); !ans.Equal(test.want) {
+				t.Errorf("got %s, want %s", ans, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expression := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expression); err == nil {
+			t.Errorf("%s : got no error", expression)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	now := time.Date(2024, time.January, 31, 22, 30, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name     string
+		schedule Schedule
+		min, max time.Time
+		wantErr  bool
+	}{
+		{"default", Schedule{}, now.Add(time.Hour), now.Add(time.Hour), false},
+		{"interval with jitter", Schedule{Interval: "10m", Jitter: "1m"}, now.Add(10 * time.Minute), now.Add(11 * time.Minute), false},
+		{"cron", Schedule{Cron: "0 23 * * *"}, now.Add(30 * time.Minute), now.Add(30 * time.Minute), false},
+		{"both", Schedule{Interval: "10m", Cron: "* * * * *"}, time.Time{}, time.Time{}, true},
+		{"never", Schedule{Cron: "0 0 31 2 *"}, time.Time{}, time.Time{}, true},
+		{"negative", Schedule{Interval: "-1m"}, time.Time{}, time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ans, err := test.schedule.Next(now)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if ans.Before(test.min) || ans.After(test.max) {
+				t.Errorf("got %s, want between %s and %s", ans, test.min, test.max)
+			}
+		})
+	}
+}
@@ -10,6 +10,8 @@ import (
 )
 
 const (
+	// cosign signatures, attestations, SBOMs and OCI referrers fallback tags
+	artifactTagRegex = "^sha256-[a-f0-9]{64}(\\.(sig|att|sbom))?$"
 	// pattern from https://semver.org/#is-there-a-suggested-regular-expression-regex-to-check-a-semver-string
 	defaultSemverRegex = "^(0|[1-9][0-9]*)\\.(0|[1-9][0-9]*)\\.(0|[1-9][0-9]*)(?:-((?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*)(?:\\.(?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\\+([0-9a-zA-Z-]+(?:\\.[0-9a-zA-Z-]+)*))?$"
 )
@@ -33,12 +35,19 @@ func containsSubstring(items []string, substring string) bool {
 }
 
 func (s *Source) matchingTags(tags []string) []string {
+	// "tags" entries may be pinned to a digest ("1.2.3@sha256:...").
+	tagNames := []string{}
+	for _, entry := range s.Tags {
+		tag, _ := splitTagPin(entry)
+		tagNames = append(tagNames, tag)
+	}
+
 	matchingTags := []string{}
 	for _, t := range tags {
 		if t == "" {
 			continue
 		}
-		if stringInSlice(t, s.Tags) {
+		if stringInSlice(t, tagNames) {
 			matchingTags = append(matchingTags, t)
 		}
 	}
@@ -187,9 +196,30 @@ func (s *Source) FilterTags(tags []string) ([]string, error) {
 
 	// remove omitted "special" tags if source specify this options.
 	finalTags := s.filterSpecialTags(filteredTags)
+
+	// artifacts are synced alongside the image they are attached to.
+	if s.SyncArtifacts {
+		finalTags = filterArtifactTags(finalTags)
+	}
 	return finalTags, nil
 }
 
+// IsArtifactTag reports whether a tag holds cosign signatures, attestations,
+// SBOMs or OCI referrers of another image rather than an image.
+func IsArtifactTag(tag string) bool {
+	return regexp.MustCompile(artifactTagRegex).MatchString(tag)
+}
+
+func filterArtifactTags(tags []string) []string {
+	filteredTags := []string{}
+	for _, tag := range tags {
+		if !IsArtifactTag(tag) {
+			filteredTags = append(filteredTags, tag)
+		}
+	}
+	return filteredTags
+}
+
 // MissingTags return the missing srcTags from dstList
 func MissingTags(srcTags []string, dstTags []string) []string {
 	missingTags := []string{}
@@ -122,3 +122,30 @@ func TestGetHighestSemverTag(t *testing.T) {
 		})
 	}
 }
+
+func TestFilterTagsArtifacts(t *testing.T) {
+	digestTag := "sha256-da9a0872ad0512636576de77b29a12b5a36a5f78c89b420b453ac8f99747f9f3"
+	tags := []string{"1.0.0", digestTag, digestTag + ".sig", digestTag + ".att", digestTag + ".sbom"}
+
+	var tests = []struct {
+		source Source
+		want   []string
+	}{
+		{Source{RegexTags: []string{".*"}}, tags},
+		{Source{RegexTags: []string{".*"}, SyncArtifacts: true}, []string{"1.0.0"}},
+		{Source{Tags: []string{digestTag + ".sig"}, SyncArtifacts: true}, []string{}},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("FilterTags artifacts %v", test.source.SyncArtifacts)
+		t.Run(testname, func(t *testing.T) {
+			ans, err := test.source.FilterTags(tags)
+			if err != nil {
+				t.Errorf("got unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(ans, test.want) {
+				t.Errorf("got '%s', want '%s'", ans, test.want)
+			}
+		})
+	}
+}
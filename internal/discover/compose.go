@@ -0,0 +1,103 @@
+package discover
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type compose struct {
+	Services map[string]struct {
+		Image    string `yaml:"image"`
+		Platform string `yaml:"platform"`
+	} `yaml:"services"`
+}
+
+// isComposeFile returns whether a file is named as a Compose file.
+func isComposeFile(base string) bool {
+	base = strings.ToLower(base)
+	for _, prefix := range []string{"docker-compose", "compose"} {
+		for _, ext := range []string{".yml", ".yaml"} {
+			if base == prefix+ext || (strings.HasPrefix(base, prefix+".") && strings.HasSuffix(base, ext)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ComposeImages returns the images of the services of a Compose file,
+// interpolating variables from the environment. Services built without
+// image are ignored.
+func ComposeImages(r io.Reader) ([]Image, error) {
+	var c compose
+	if err := yaml.NewDecoder(r).Decode(&c); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	services := []string{}
+	for service := range c.Services {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	images := []Image{}
+	for _, service := range services {
+		s := c.Services[service]
+		if s.Image == "" {
+			continue
+		}
+		platform, _ := expand(s.Platform, os.LookupEnv)
+		reference, ok := expand(s.Image, os.LookupEnv)
+		if !ok {
+			// Left as-is to be reported as unresolved.
+			reference = s.Image
+		}
+		images = append(images, Image{Reference: reference, Platform: platform})
+	}
+	return images, nil
+}
+
+// expand substitutes $VAR, ${VAR}, ${VAR:-default}, ${VAR-default} and
+// ${VAR:+alternate} with lookup, and returns whether every variable was
+// resolved.
+func expand(s string, lookup func(string) (string, bool)) (string, bool) {
+	resolved := true
+	expanded := os.Expand(s, func(variable string) string {
+		// The operator follows the variable name, defaults may contain one.
+		i := strings.IndexFunc(variable, func(r rune) bool {
+			return !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+		})
+		for _, operator := range []string{":-", "-", ":+"} {
+			if i < 0 || !strings.HasPrefix(variable[i:], operator) {
+				continue
+			}
+			value, ok := lookup(variable[:i])
+			switch operator {
+			case ":-":
+				if !ok || value == "" {
+					return variable[i+2:]
+				}
+			case "-":
+				if !ok {
+					return variable[i+1:]
+				}
+			case ":+":
+				if ok && value != "" {
+					return variable[i+2:]
+				}
+				return ""
+			}
+			return value
+		}
+		value, ok := lookup(variable)
+		if !ok {
+			resolved = false
+		}
+		return value
+	})
+	return expanded, resolved
+}
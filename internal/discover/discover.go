@@ -0,0 +1,171 @@
+// Package discover finds the images referenced by deployment files, to
+// keep sync sources aligned with what actually runs.
+package discover
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Image is an image reference found in a file, with the platform it is
+// pulled for when set.
+type Image struct {
+	Reference string
+	Platform  string
+	File      string
+}
+
+// Walk returns the images referenced by the files of paths, walking
+// directories but hidden ones. Files of unknown kinds are ignored, files
+// that cannot be parsed are returned as errors apart.
+func Walk(paths []string) ([]Image, []error, error) {
+	images := []Image{}
+	failed := []error{}
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if path != root && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			found, err := fileImages(path)
+			if err != nil {
+				failed = append(failed, fmt.Errorf("%s : %w", path, err))
+				return nil
+			}
+			for _, image := range found {
+				image.File = path
+				images = append(images, image)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("discover walk : %w", err)
+		}
+	}
+	return images, failed, nil
+}
+
+// fileImages returns the images referenced by a file, according to its
+// name: Dockerfiles, Compose files, and other YAML files as Kubernetes
+// manifests.
+func fileImages(path string) ([]Image, error) {
+	var parse func(io.Reader) ([]Image, error)
+	base := filepath.Base(path)
+	switch {
+	case isDockerfile(base):
+		parse = DockerfileImages
+	case isComposeFile(base):
+		parse = ComposeImages
+	case strings.HasSuffix(strings.ToLower(base), ".yaml"), strings.HasSuffix(strings.ToLower(base), ".yml"):
+		parse = KubernetesImages
+	default:
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parse(f)
+}
+
+// Sources groups images by repository into sources syncing their exact
+// tags, pinned when referenced by digest, and their platforms when every
+// image of the repository sets one. Untagged and "latest" references,
+// which move upstream, are added as mutable tags unless pinned. Images
+// that cannot be selected by tag, referenced by digest only or with
+// unresolved variables, are returned apart.
+func Sources(images []Image) ([]config.Source, []Image) {
+	tags := map[string][]string{}
+	mutableTags := map[string][]string{}
+	platforms := map[string][]string{}
+	allPlatforms := map[string]bool{}
+	skipped := []Image{}
+	for _, image := range images {
+		base, digest := image.Reference, ""
+		if i := strings.Index(base, "@"); i >= 0 {
+			base, digest = base[:i], base[i+1:]
+		}
+		ref, err := name.NewTag(base)
+		if err != nil || (digest != "" && !hasTag(base)) {
+			skipped = append(skipped, image)
+			continue
+		}
+
+		tag := ref.TagStr()
+		if digest != "" {
+			tag += "@" + digest
+		}
+		repository := ref.Context().Name()
+		if tag == name.DefaultTag {
+			if !stringInSlice(tag, mutableTags[repository]) {
+				mutableTags[repository] = append(mutableTags[repository], tag)
+			}
+		} else if !stringInSlice(tag, tags[repository]) {
+			tags[repository] = append(tags[repository], tag)
+		}
+		if image.Platform == "" {
+			allPlatforms[repository] = true
+		} else if !stringInSlice(image.Platform, platforms[repository]) {
+			platforms[repository] = append(platforms[repository], image.Platform)
+		}
+	}
+
+	repositories := []string{}
+	for repository := range tags {
+		repositories = append(repositories, repository)
+	}
+	for repository := range mutableTags {
+		if _, ok := tags[repository]; !ok {
+			repositories = append(repositories, repository)
+		}
+	}
+	sort.Strings(repositories)
+
+	sources := []config.Source{}
+	for _, repository := range repositories {
+		ref, _ := name.NewRepository(repository)
+		source := config.Source{Source: config.Repo{Repository: ref.RepositoryStr()}}
+		if ref.RegistryStr() == name.DefaultRegistry {
+			source.Source.Repository = strings.TrimPrefix(ref.RepositoryStr(), "library/")
+		} else {
+			source.Source.Host = ref.RegistryStr()
+		}
+		source.Tags = tags[repository]
+		sort.Strings(source.Tags)
+		source.MutableTags = mutableTags[repository]
+		if !allPlatforms[repository] {
+			source.Platforms = platforms[repository]
+			sort.Strings(source.Platforms)
+		}
+		sources = append(sources, source)
+	}
+	return sources, skipped
+}
+
+// hasTag returns whether a reference without digest has an explicit tag.
+func hasTag(reference string) bool {
+	return strings.Contains(reference[strings.LastIndex(reference, "/")+1:], ":")
+}
+
+func stringInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
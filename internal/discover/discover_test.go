@@ -0,0 +1,150 @@
+package discover
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/barthv/imgsync/internal/config"
+)
+
+const manifests = `
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: registry.example.com/team/migrate:1.0.0
+      containers:
+      - name: web
+        image: nginx:1.25
+      - name: exporter
+        image: quay.io/prometheus/nginx-exporter:v1.1.0@sha256:0000000000000000000000000000000000000000000000000000000000000000
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - image: busybox
+---
+apiVersion: v1
+kind: List
+items:
+- kind: Pod
+  spec:
+    containers:
+    - image: nginx:1.25
+    ephemeralContainers:
+    - image: busybox@sha256:1111111111111111111111111111111111111111111111111111111111111111
+- kind: StatefulSet
+  spec:
+    template:
+      spec:
+        containers:
+        - image: docker.io/bitnami/redis:7.2
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  spec: not a pod spec
+---
+`
+
+func TestKubernetesImages(t *testing.T) {
+	images, err := KubernetesImages(strings.NewReader(manifests))
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	want := []string{
+		"registry.example.com/team/migrate:1.0.0",
+		"nginx:1.25",
+		"quay.io/prometheus/nginx-exporter:v1.1.0@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		"busybox",
+		"nginx:1.25",
+		"busybox@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"docker.io/bitnami/redis:7.2",
+	}
+	references := []string{}
+	for _, image := range images {
+		references = append(references, image.Reference)
+	}
+	if !reflect.DeepEqual(references, want) {
+		t.Errorf("got %v, want %v", references, want)
+	}
+}
+
+func TestSources(t *testing.T) {
+	images, err := KubernetesImages(strings.NewReader(manifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images = append(images,
+		Image{Reference: "registry.example.com/team/migrate:1.0.0", Platform: "linux/arm64"},
+		Image{Reference: "alpine:3.19", Platform: "linux/arm64"},
+		Image{Reference: "alpine:3.19", Platform: "linux/amd64"},
+		Image{Reference: "node:${NODE_VERSION}"},
+	)
+
+	sources, skipped := Sources(images)
+	want := []config.Source{
+		{Source: config.Repo{Repository: "bitnami/redis"}, Tags: []string{"7.2"}},
+		{Source: config.Repo{Repository: "alpine"}, Tags: []string{"3.19"}, Platforms: []string{"linux/amd64", "linux/arm64"}},
+		{Source: config.Repo{Repository: "busybox"}, MutableTags: []string{"latest"}},
+		{Source: config.Repo{Repository: "nginx"}, Tags: []string{"1.25"}},
+		{Source: config.Repo{Host: "quay.io", Repository: "prometheus/nginx-exporter"}, Tags: []string{"v1.1.0@sha256:0000000000000000000000000000000000000000000000000000000000000000"}},
+		{Source: config.Repo{Host: "registry.example.com", Repository: "team/migrate"}, Tags: []string{"1.0.0"}},
+	}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("got %+v, want %+v", sources, want)
+	}
+	if len(skipped) != 2 || !strings.HasPrefix(skipped[0].Reference, "busybox@") {
+		t.Errorf("got skipped %v, want the busybox digest and node references", skipped)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	root, err := ioutil.TempDir("", "discover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"Dockerfile":          "FROM golang:1.21\n",
+		"deploy/broken.yaml":  "spec: [\n",
+		".git/hooks/app.yaml": "image: nginx:1.25\n",
+		"README.md":           "FROM nginx:1.25\n",
+	}
+	for file, contents := range files {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	images, failed, err := Walk([]string{root})
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if len(images) != 1 || images[0].Reference != "golang:1.21" {
+		t.Errorf("got images %v, want the Dockerfile image only", images)
+	}
+	if len(failed) != 1 || !strings.Contains(failed[0].Error(), "broken.yaml") {
+		t.Errorf("got failed %v, want broken.yaml", failed)
+	}
+}
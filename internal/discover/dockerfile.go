@@ -0,0 +1,113 @@
+package discover
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// isDockerfile returns whether a file is named as a Dockerfile.
+func isDockerfile(base string) bool {
+	lower := strings.ToLower(base)
+	return lower == "dockerfile" || lower == "containerfile" ||
+		strings.HasPrefix(lower, "dockerfile.") || strings.HasSuffix(lower, ".dockerfile")
+}
+
+// dockerfileInstructions returns the instructions of a Dockerfile, with
+// line continuations joined and comments left out.
+func dockerfileInstructions(r io.Reader) ([]string, error) {
+	instructions := []string{}
+	current := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") || (line == "" && current == "") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		instructions = append(instructions, current+line)
+		current = ""
+	}
+	if current != "" {
+		instructions = append(instructions, current)
+	}
+	return instructions, scanner.Err()
+}
+
+// DockerfileImages returns the base images of the stages of a Dockerfile.
+// ARG instructions before the first FROM are substituted with their
+// default values, and FROM lines referring to a previous stage or to
+// scratch are left out.
+func DockerfileImages(r io.Reader) ([]Image, error) {
+	instructions, err := dockerfileInstructions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]string{}
+	lookup := func(variable string) (string, bool) {
+		value, ok := args[variable]
+		return value, ok
+	}
+	stages := map[string]bool{}
+	inStage := false
+	images := []Image{}
+	for _, instruction := range instructions {
+		fields := strings.Fields(instruction)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if inStage {
+				// Stage arguments do not apply to FROM lines.
+				continue
+			}
+			for _, arg := range fields[1:] {
+				parts := strings.SplitN(arg, "=", 2)
+				if len(parts) == 2 {
+					args[parts[0]] = strings.Trim(parts[1], "\"'")
+				}
+			}
+
+		case "FROM":
+			platform := ""
+			operands := []string{}
+			for _, field := range fields[1:] {
+				if strings.HasPrefix(field, "--platform=") {
+					platform = strings.TrimPrefix(field, "--platform=")
+					continue
+				}
+				operands = append(operands, field)
+			}
+			if len(operands) == 0 {
+				continue
+			}
+			inStage = true
+
+			reference, ok := expand(operands[0], lookup)
+			if !ok {
+				// Left as-is to be reported as unresolved.
+				reference = operands[0]
+			}
+			isStage := stages[strings.ToLower(reference)]
+			if len(operands) >= 3 && strings.EqualFold(operands[1], "as") {
+				stages[strings.ToLower(operands[2])] = true
+			}
+			if reference == "scratch" || isStage {
+				continue
+			}
+			// Automatic platform arguments mean every platform.
+			if platform, ok := expand(platform, lookup); ok {
+				images = append(images, Image{Reference: reference, Platform: platform})
+			} else {
+				images = append(images, Image{Reference: reference})
+			}
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,118 @@
+package discover
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDockerfileImages(t *testing.T) {
+	dockerfile := `# syntax=docker/dockerfile:1
+ARG GO_VERSION=1.21
+ARG REGISTRY="registry.example.com"
+ARG BASE
+FROM --platform=$BUILDPLATFORM golang:${GO_VERSION} AS build
+ARG GO_VERSION=1.0
+RUN go build \
+  -o /app
+FROM build AS test
+FROM --platform=linux/arm64 \
+  ${REGISTRY}/base/distroless:${DISTROLESS_TAG:-nonroot}
+COPY --from=build /app /app
+FROM scratch
+FROM $BASE
+`
+	images, err := DockerfileImages(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	want := []Image{
+		{Reference: "golang:1.21"},
+		{Reference: "registry.example.com/base/distroless:nonroot", Platform: "linux/arm64"},
+		{Reference: "$BASE"},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("got %+v, want %+v", images, want)
+	}
+}
+
+func TestComposeImages(t *testing.T) {
+	compose := `services:
+  web:
+    image: nginx:${NGINX_TAG:-1.25}
+    platform: linux/amd64
+  app:
+    build: .
+  db:
+    image: postgres:16
+`
+	images, err := ComposeImages(strings.NewReader(compose))
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	want := []Image{
+		{Reference: "postgres:16"},
+		{Reference: "nginx:1.25", Platform: "linux/amd64"},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("got %+v, want %+v", images, want)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	lookup := func(variable string) (string, bool) {
+		value, ok := map[string]string{"TAG": "1.25", "EMPTY": ""}[variable]
+		return value, ok
+	}
+
+	var tests = []struct {
+		s            string
+		want         string
+		wantResolved bool
+	}{
+		{"nginx:${TAG}", "nginx:1.25", true},
+		{"nginx:${EMPTY:-1.24}", "nginx:1.24", true},
+		{"nginx:${EMPTY-1.24}", "nginx:", true},
+		{"nginx:${UNSET:-1.24-alpine}", "nginx:1.24-alpine", true},
+		{"nginx:${TAG:+1.25-alpine}", "nginx:1.25-alpine", true},
+		{"nginx:${UNSET:+x-y}", "nginx:", true},
+		{"nginx:$UNSET", "nginx:", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			got, resolved := expand(test.s, lookup)
+			if got != test.want || resolved != test.wantResolved {
+				t.Errorf("got %s, %t, want %s, %t", got, resolved, test.want, test.wantResolved)
+			}
+		})
+	}
+}
+
+func TestFileImages(t *testing.T) {
+	var tests = []struct {
+		base       string
+		dockerfile bool
+		compose    bool
+	}{
+		{"Dockerfile", true, false},
+		{"Dockerfile.prod", true, false},
+		{"api.dockerfile", true, false},
+		{"Containerfile", true, false},
+		{"docker-compose.yml", false, true},
+		{"docker-compose.override.yaml", false, true},
+		{"compose.yaml", false, true},
+		{"deployment.yaml", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.base, func(t *testing.T) {
+			if ans := isDockerfile(test.base); ans != test.dockerfile {
+				t.Errorf("got dockerfile %t, want %t", ans, test.dockerfile)
+			}
+			if ans := isComposeFile(test.base); ans != test.compose {
+				t.Errorf("got compose %t, want %t", ans, test.compose)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package discover
+
+import (
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type container struct {
+	Image string `yaml:"image"`
+}
+
+type podSpec struct {
+	Containers          []container `yaml:"containers"`
+	InitContainers      []container `yaml:"initContainers"`
+	EphemeralContainers []container `yaml:"ephemeralContainers"`
+}
+
+type podTemplate struct {
+	Spec podSpec `yaml:"spec"`
+}
+
+// workload holds the pod specs of every kind of workload.
+type workload struct {
+	Kind string `yaml:"kind"`
+	Spec struct {
+		podSpec     `yaml:",inline"`
+		Template    podTemplate `yaml:"template"`
+		JobTemplate struct {
+			Spec struct {
+				Template podTemplate `yaml:"template"`
+			} `yaml:"spec"`
+		} `yaml:"jobTemplate"`
+	} `yaml:"spec"`
+}
+
+// podSpec returns the pod spec of a workload, false for other kinds.
+func (w *workload) podSpec() (podSpec, bool) {
+	switch w.Kind {
+	case "Pod":
+		return w.Spec.podSpec, true
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job", "PodTemplate":
+		return w.Spec.Template.Spec, true
+	case "CronJob":
+		return w.Spec.JobTemplate.Spec.Template.Spec, true
+	}
+	return podSpec{}, false
+}
+
+// KubernetesImages returns the images of the containers, init and
+// ephemeral containers of the workloads of a multi-document YAML stream,
+// as written or rendered by Helm. List items are walked too.
+func KubernetesImages(r io.Reader) ([]Image, error) {
+	images := []Image{}
+	decoder := yaml.NewDecoder(r)
+	for {
+		var document interface{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			return images, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		found, err := documentImages(document)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, found...)
+	}
+}
+
+func documentImages(document interface{}) ([]Image, error) {
+	object, ok := document.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil
+	}
+	if kind, _ := object["kind"].(string); strings.HasSuffix(kind, "List") {
+		items, _ := object["items"].([]interface{})
+		images := []Image{}
+		for _, item := range items {
+			found, err := documentImages(item)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, found...)
+		}
+		return images, nil
+	}
+
+	// Only workloads are decoded, other kinds may not match their fields.
+	var w workload
+	w.Kind, _ = object["kind"].(string)
+	if _, ok := w.podSpec(); !ok {
+		return nil, nil
+	}
+	raw, err := yaml.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(raw, &w); err != nil {
+		return nil, err
+	}
+
+	spec, _ := w.podSpec()
+	images := []Image{}
+	for _, containers := range [][]container{spec.InitContainers, spec.Containers, spec.EphemeralContainers} {
+		for _, c := range containers {
+			if c.Image != "" {
+				images = append(images, Image{Reference: c.Image})
+			}
+		}
+	}
+	return images, nil
+}
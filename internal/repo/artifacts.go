@@ -0,0 +1,194 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// cosign stores signatures, attestations and SBOMs under tags derived
+// from the digest of the image they refer to.
+var artifactTagSuffixes = []string{".sig", ".att", ".sbom"}
+
+// artifactTag returns the tag-schema name of a digest ("sha256-<hex>").
+func artifactTag(digest v1.Hash) string {
+	return digest.Algorithm + "-" + digest.Hex
+}
+
+// listTargetDigests returns the digest of a tag written to the
+// destination and, for multi-arch tags, the digests of each of its
+// manifests.
+func listTargetDigests(target string, tag string) ([]v1.Hash, error) {
+	// A HEAD request is enough for single-arch tags of registries.
+	if _, ok := destination.(Registry); ok {
+		ref, err := name.ParseReference(reference(target, tag))
+		if err != nil {
+			return nil, err
+		}
+		desc, err := remote.Head(ref, remoteOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		if !desc.MediaType.IsIndex() {
+			return []v1.Hash{desc.Digest}, nil
+		}
+	}
+
+	manifest, desc, err := readTarget(target, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := []v1.Hash{desc.Digest}
+	idx, ok := manifest.(v1.ImageIndex)
+	if !ok {
+		return digests, nil
+	}
+
+	indexManifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range indexManifest.Manifests {
+		digests = append(digests, child.Digest)
+	}
+	return digests, nil
+}
+
+// copyReferrers copies the OCI referrers of a digest. Only registries
+// have a referrers API.
+func copyReferrers(source string, target string, digest v1.Hash) (int, error) {
+	if _, ok := sourceFor(source).(Registry); !ok {
+		return 0, nil
+	}
+	subject, err := name.NewDigest(source + "@" + digest.String())
+	if err != nil {
+		return 0, err
+	}
+
+	referrers, err := remote.Referrers(subject, remoteOptions()...)
+	if err != nil {
+		return 0, err
+	}
+	manifest, err := referrers.IndexManifest()
+	if err != nil {
+		return 0, err
+	}
+
+	// pushing a manifest with a subject by digest also registers it
+	// in the target referrers API (or its fallback tag).
+	for _, desc := range manifest.Manifests {
+		if err := copyManifest(source, desc.Digest.String(), desc.Digest.String(), target); err != nil {
+			return 0, err
+		}
+	}
+	return len(manifest.Manifests), nil
+}
+
+// SyncArtifactsBetweenRepos copies the cosign signatures, attestations and
+// SBOMs (tag-schema) and the OCI referrers attached to a synced tag, and to
+// each manifest of a multi-arch tag. tag is the target tag: only artifacts
+// of manifests found in the target are copied, as those of a source index
+// changed by platform filtering or assembly would refer to missing
+// digests. sourceTags is the source tag list, used to look up tag-schema
+// artifacts. It returns the number of copied artifacts.
+func SyncArtifactsBetweenRepos(tag string, source string, target string, sourceTags []string) (int, error) {
+	digests, err := listTargetDigests(target, tag)
+	if err != nil {
+		return 0, fmt.Errorf("repo copy artifacts : %w", err)
+	}
+
+	copied := 0
+	for _, digest := range digests {
+		for _, suffix := range artifactTagSuffixes {
+			artifact := artifactTag(digest) + suffix
+			if !stringInSlice(artifact, sourceTags) {
+				continue
+			}
+			if err := copyManifest(source, artifact, artifact, target); err != nil {
+				return copied, fmt.Errorf("repo copy artifact %s : %w", artifact, err)
+			}
+			copied++
+		}
+
+		referrers, err := copyReferrers(source, target, digest)
+		copied += referrers
+		if err != nil {
+			return copied, fmt.Errorf("repo copy referrers of %s : %w", digest, err)
+		}
+	}
+
+	return copied, nil
+}
+
+// SyncMissingArtifacts copies the tag-schema artifacts of tags already
+// synced to the target, e.g. signatures added upstream after the image was
+// synced. Target digests are only read when some source artifact is
+// missing from targetTags. digests maps tags to their source digest when
+// they are synced as-is: such a tag is only read when an artifact of its
+// digest is missing, as artifacts of unselected images never match it.
+// Referrers are only copied with their tag.
+func SyncMissingArtifacts(tags []string, digests map[string]string, source string, target string, sourceTags []string, targetTags []string) (int, error) {
+	missing := map[string]bool{}
+	for _, tag := range sourceTags {
+		for _, suffix := range artifactTagSuffixes {
+			if strings.HasSuffix(tag, suffix) && !stringInSlice(tag, targetTags) {
+				missing[tag] = true
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	copied := 0
+	for _, tag := range tags {
+		if digest, ok := digests[tag]; ok && !missingArtifact(missing, digest) {
+			continue
+		}
+		targetDigests, err := listTargetDigests(target, tag)
+		if err != nil {
+			return copied, fmt.Errorf("repo copy artifacts : %w", err)
+		}
+		for _, digest := range targetDigests {
+			for _, suffix := range artifactTagSuffixes {
+				artifact := artifactTag(digest) + suffix
+				if !missing[artifact] {
+					continue
+				}
+				if err := copyManifest(source, artifact, artifact, target); err != nil {
+					return copied, fmt.Errorf("repo copy artifact %s : %w", artifact, err)
+				}
+				delete(missing, artifact)
+				copied++
+			}
+		}
+	}
+	return copied, nil
+}
+
+// missingArtifact reports whether an artifact of digest is missing.
+func missingArtifact(missing map[string]bool, digest string) bool {
+	h, err := v1.NewHash(digest)
+	if err != nil {
+		return true
+	}
+	for _, suffix := range artifactTagSuffixes {
+		if missing[artifactTag(h)+suffix] {
+			return true
+		}
+	}
+	return false
+}
+
+func stringInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package repo
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// platformIndex returns an index of random images, one per platform.
+func platformIndex(t *testing.T, platforms ...string) v1.ImageIndex {
+	t.Helper()
+	parsed, err := ParsePlatforms(platforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addenda := []mutate.IndexAddendum{}
+	for i := range parsed {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addenda = append(addenda, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &parsed[i]}})
+	}
+	return mutate.AppendManifests(empty.Index, addenda...)
+}
+
+func pushTestManifest(t *testing.T, ref string, manifest remote.Taggable) {
+	t.Helper()
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Push(parsed, manifest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestSyncArtifactsBetweenRepos(t *testing.T) {
+	host := newTestRegistry(t)
+	source, target := host+"/app", host+"/mirror/app"
+
+	idx := platformIndex(t, "linux/amd64", "linux/arm64")
+	pushTestManifest(t, source+":1.0", idx)
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTestManifest(t, source+":2.0", img)
+
+	indexManifest, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idxDigest, err := idx.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := []v1.Hash{idxDigest, indexManifest.Manifests[0].Digest, indexManifest.Manifests[1].Digest}
+	for _, digest := range signed {
+		sig, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pushTestManifest(t, source+":"+artifactTag(digest)+".sig", sig)
+	}
+
+	if err := SyncTagBetweenRepos("1.0", source, target, []string{"linux/amd64"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncTagBetweenRepos("2.0", source, target, nil); err != nil {
+		t.Fatal(err)
+	}
+	sourceTags, err := crane.ListTags(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The filtered index is a new manifest: only the signature of the
+	// amd64 image refers to a digest found in the target.
+	copied, err := SyncArtifactsBetweenRepos("1.0", source, target, sourceTags)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if copied != 1 {
+		t.Errorf("got %d artifacts copied, want 1", copied)
+	}
+
+	// 2.0 is signed once already synced.
+	sig, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTestManifest(t, source+":"+artifactTag(imgDigest)+".sig", sig)
+	sourceTags, err = crane.ListTags(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetTags, err := crane.ListTags(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err = SyncMissingArtifacts([]string{"1.0", "2.0"}, map[string]string{"2.0": imgDigest.String()}, source, target, sourceTags, targetTags)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if copied != 1 {
+		t.Errorf("got %d missing artifacts copied, want 1", copied)
+	}
+
+	// A tag whose digest has no missing artifact is not read: 3.0 is not
+	// in the target.
+	copied, err = SyncMissingArtifacts([]string{"3.0"}, map[string]string{"3.0": indexManifest.Manifests[0].Digest.String()}, source, target, sourceTags, targetTags)
+	if err != nil || copied != 0 {
+		t.Errorf("got %d, %v, want 0 artifacts copied without reading 3.0", copied, err)
+	}
+
+	targetTags, err = crane.ListTags(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(targetTags)
+	want := []string{"1.0", "2.0", artifactTag(indexManifest.Manifests[0].Digest) + ".sig", artifactTag(imgDigest) + ".sig"}
+	sort.Strings(want)
+	if strings.Join(targetTags, ",") != strings.Join(want, ",") {
+		t.Errorf("got target tags %v, want %v", targetTags, want)
+	}
+}
@@ -0,0 +1,157 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// archAddenda returns the index entries provided by a single source tag
+// or digest.
+// Images are described with the given platform (or their own config
+// platform), indexes are flattened into their child manifests.
+func archAddenda(source string, tag string, platform string) ([]mutate.IndexAddendum, error) {
+	manifest, desc, err := getManifest(source, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if idx, ok := manifest.(v1.ImageIndex); ok {
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		addenda := []mutate.IndexAddendum{}
+		for _, child := range manifest.Manifests {
+			if !child.MediaType.IsImage() {
+				continue
+			}
+			img, err := idx.Image(child.Digest)
+			if err != nil {
+				return nil, err
+			}
+			addenda = append(addenda, mutate.IndexAddendum{Add: img, Descriptor: child})
+		}
+		return addenda, nil
+	}
+
+	img, ok := manifest.(v1.Image)
+	if !ok {
+		return nil, fmt.Errorf("%s is neither an image nor an index", reference(source, tag))
+	}
+
+	var p *v1.Platform
+	if platform != "" {
+		p, err = v1.ParsePlatform(platform)
+	} else {
+		p, err = imagePlatform(img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []mutate.IndexAddendum{{
+		Add: img,
+		Descriptor: v1.Descriptor{
+			MediaType: desc.MediaType,
+			Platform:  p,
+		},
+	}}, nil
+}
+
+func platformInSlice(p v1.Platform, platforms []v1.Platform) bool {
+	for _, platform := range platforms {
+		if p.Equals(platform) {
+			return true
+		}
+	}
+	return false
+}
+
+// AssembleTagsBetweenRepos builds a multi-arch image index from several
+// single-arch source tags and pushes it to target as targetTag.
+// members maps each source tag to its platform ("" to read it from the
+// image config). Explicit platforms take precedence over guessed ones.
+// digests maps source tags to the manifest digest to read instead, e.g.
+// the verified one; other tags are read by name.
+// A single member is copied as-is instead, so that its digest is kept.
+// It returns the digest of the pushed index or image.
+func AssembleTagsBetweenRepos(members map[string]string, digests map[string]string, targetTag string, source string, target string, platforms []string) (string, error) {
+	if len(members) == 1 {
+		for tag := range members {
+			ref := tag
+			if digest, ok := digests[tag]; ok {
+				ref = digest
+			}
+			if err := copyBetweenRepos(source, ref, targetTag, target, platforms); err != nil {
+				return "", fmt.Errorf("repo assemble tag %s : %w", tag, err)
+			}
+		}
+		_, desc, err := readTarget(target, targetTag)
+		if err != nil {
+			return "", fmt.Errorf("repo assemble tag : %w", err)
+		}
+		return desc.Digest.String(), nil
+	}
+
+	// suffixed tags first so that their platforms win over unsuffixed ones
+	tags := []string{}
+	for tag := range members {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if (members[tags[i]] == "") != (members[tags[j]] == "") {
+			return members[tags[i]] != ""
+		}
+		return tags[i] < tags[j]
+	})
+
+	addenda := []mutate.IndexAddendum{}
+	seenPlatforms := []v1.Platform{}
+	for _, tag := range tags {
+		ref := tag
+		if digest, ok := digests[tag]; ok {
+			ref = digest
+		}
+		tagAddenda, err := archAddenda(source, ref, members[tag])
+		if err != nil {
+			return "", fmt.Errorf("repo assemble tag %s : %w", tag, err)
+		}
+		for _, add := range tagAddenda {
+			if add.Platform != nil {
+				if platformInSlice(*add.Platform, seenPlatforms) {
+					continue
+				}
+				seenPlatforms = append(seenPlatforms, *add.Platform)
+			}
+			addenda = append(addenda, add)
+		}
+	}
+
+	var idx v1.ImageIndex = mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), addenda...)
+
+	if len(platforms) > 0 {
+		parsedPlatforms, err := ParsePlatforms(platforms)
+		if err != nil {
+			return "", fmt.Errorf("repo assemble tag : %w", err)
+		}
+		idx, err = filterIndexPlatforms(idx, parsedPlatforms)
+		if err != nil {
+			return "", fmt.Errorf("repo assemble tag : %w", err)
+		}
+	}
+
+	digest, err := idx.Digest()
+	if err != nil {
+		return "", fmt.Errorf("repo assemble tag : %w", err)
+	}
+	if err := write(targetTag, target, idx); err != nil {
+		return "", fmt.Errorf("repo assemble tag : %w", err)
+	}
+
+	return digest.String(), nil
+}
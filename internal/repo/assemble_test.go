@@ -0,0 +1,139 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestAssembleTagsBetweenRepos(t *testing.T) {
+	host := newTestRegistry(t)
+	source, target := host+"/app", host+"/mirror/app"
+
+	amd64 := platformImage(t, "linux/amd64")
+	arm64 := platformImage(t, "linux/arm64")
+	pushTestManifest(t, source+":1.0-amd64", amd64)
+	pushTestManifest(t, source+":1.0-arm64v8", arm64)
+	// The unsuffixed tag also provides linux/amd64, the suffixed tag wins.
+	pushTestManifest(t, source+":1.0", platformIndex(t, "linux/amd64", "linux/s390x"))
+	members := map[string]string{"1.0-amd64": "linux/amd64", "1.0-arm64v8": "linux/arm64/v8", "1.0": ""}
+
+	var tests = []struct {
+		name      string
+		platforms []string
+		want      []string
+	}{
+		{"all platforms", nil, []string{"linux/amd64", "linux/arm64/v8", "linux/s390x"}},
+		{"filtered platforms", []string{"linux/amd64", "linux/s390x"}, []string{"linux/amd64", "linux/s390x"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			digest, err := AssembleTagsBetweenRepos(members, nil, "1.0", source, target, test.platforms)
+			if err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			ref, err := name.ParseReference(target + ":1.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			idx, err := remote.Index(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := idx.Digest(); got.String() != digest {
+				t.Errorf("got pushed digest %s, want returned %s", got, digest)
+			}
+			manifest, err := idx.IndexManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]v1.Hash{}
+			for _, desc := range manifest.Manifests {
+				got[desc.Platform.String()] = desc.Digest
+			}
+			if len(got) != len(test.want) {
+				t.Errorf("got platforms %v, want %v", got, test.want)
+			}
+			for _, platform := range test.want {
+				if _, ok := got[platform]; !ok {
+					t.Errorf("got platforms %v, want %s", got, platform)
+				}
+			}
+			for platform, img := range map[string]v1.Image{"linux/amd64": amd64, "linux/arm64/v8": arm64} {
+				want, err := img.Digest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if digest, ok := got[platform]; ok && digest != want {
+					t.Errorf("got %s digest %s, want the suffixed tag image %s", platform, digest, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAssembleTagsBetweenReposDigests(t *testing.T) {
+	host := newTestRegistry(t)
+	source, target := host+"/app", host+"/mirror/app"
+
+	verified := platformImage(t, "linux/amd64")
+	pushTestManifest(t, source+":1.0-amd64", verified)
+	digest, err := TagDigest("1.0-amd64", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTestManifest(t, source+":1.0-arm64", platformImage(t, "linux/arm64"))
+	// The tag moves upstream once its digest is verified.
+	pushTestManifest(t, source+":1.0-amd64", platformImage(t, "linux/amd64"))
+
+	members := map[string]string{"1.0-amd64": "linux/amd64", "1.0-arm64": "linux/arm64"}
+	if _, err := AssembleTagsBetweenRepos(members, map[string]string{"1.0-amd64": digest}, "1.0", source, target, nil); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	ref, err := name.ParseReference(target + ":1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := remote.Index(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Manifests) != 2 || manifest.Manifests[0].Digest.String() != digest {
+		t.Errorf("got manifests %v, want the verified digest %s", manifest.Manifests, digest)
+	}
+}
+
+func TestAssembleTagsBetweenReposSingleMember(t *testing.T) {
+	host := newTestRegistry(t)
+	source, target := host+"/app", host+"/mirror/app"
+
+	img := platformImage(t, "linux/arm64")
+	pushTestManifest(t, source+":1.0-arm64", img)
+	want, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single member is not wrapped in an index: pinned digests still match.
+	digest, err := AssembleTagsBetweenRepos(map[string]string{"1.0-arm64": "linux/arm64"}, nil, "1.0", source, target, nil)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if digest != want.String() {
+		t.Errorf("got digest %s, want the member digest %s", digest, want)
+	}
+	targetDigest, err := TagDigest("1.0", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targetDigest != want.String() {
+		t.Errorf("got target digest %s, want %s", targetDigest, want)
+	}
+}
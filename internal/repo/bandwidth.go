@@ -0,0 +1,185 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// bandwidthChunk is the size of reads accounted at once by limiters.
+const bandwidthChunk = 32 * 1024
+
+// byteLimiter is a token bucket of bytes, shared by every transfer it
+// throttles.
+type byteLimiter struct {
+	mu sync.Mutex
+	// perSecond is the bucket rate in bytes, 0 for no limit.
+	perSecond int64
+	tokens    float64
+	last      time.Time
+}
+
+// reserve takes n bytes and returns how long to wait before using them.
+func (l *byteLimiter) reserve(n int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.perSecond <= 0 {
+		return 0
+	}
+	rate := float64(l.perSecond)
+	if l.last.IsZero() {
+		l.tokens = rate
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+		if l.tokens > rate {
+			l.tokens = rate
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / rate * float64(time.Second))
+}
+
+func (l *byteLimiter) setLimit(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.perSecond <= 0 || bytesPerSecond < l.perSecond {
+		l.perSecond = bytesPerSecond
+	}
+}
+
+func (l *byteLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.perSecond, l.tokens, l.last = 0, 0, time.Time{}
+}
+
+// throttledReader reads at the pace of all its limiters, until ctx is
+// done.
+type throttledReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	limiters []*byteLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > bandwidthChunk {
+		p = p[:bandwidthChunk]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		now := time.Now()
+		wait := time.Duration(0)
+		for _, l := range r.limiters {
+			if w := l.reserve(n, now); w > wait {
+				wait = w
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-r.ctx.Done():
+				return n, r.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
+
+// registryHost returns the host of the registry a request is made to,
+// the host of the first request when following redirects to blob storage.
+func registryHost(req *http.Request) string {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req.URL.Host
+}
+
+// bandwidthTransport throttles request and response bodies, globally and
+// per registry host.
+type bandwidthTransport struct {
+	base   http.RoundTripper
+	global *byteLimiter
+	mu     sync.Mutex
+	hosts  map[string]*byteLimiter
+}
+
+func (t *bandwidthTransport) limiter(host string) *byteLimiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.hosts[host]
+	if !ok {
+		l = &byteLimiter{}
+		t.hosts[host] = l
+	}
+	return l
+}
+
+// resetLimits drops the global and host limits.
+func (t *bandwidthTransport) resetLimits() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.global.reset()
+	for _, l := range t.hosts {
+		l.reset()
+	}
+}
+
+func (t *bandwidthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiters := []*byteLimiter{t.global, t.limiter(registryHost(req))}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		ctx := req.Context()
+		req = req.Clone(ctx)
+		req.Body = &throttledReader{ReadCloser: req.Body, ctx: ctx, limiters: limiters}
+		// Bodies replayed on redirects or retries are throttled too.
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil || body == http.NoBody {
+					return body, err
+				}
+				return &throttledReader{ReadCloser: body, ctx: ctx, limiters: limiters}, nil
+			}
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = &throttledReader{ReadCloser: resp.Body, ctx: req.Context(), limiters: limiters}
+	return resp, nil
+}
+
+// SetBandwidthLimit throttles transfers to and from all registries, in
+// bytes per second. The lowest limit set wins.
+func SetBandwidthLimit(bytesPerSecond int64) {
+	bandwidth.global.setLimit(bytesPerSecond)
+}
+
+// SetHostBandwidthLimit throttles transfers to and from the registry host
+// of a repository address, in bytes per second. The lowest limit set wins.
+func SetHostBandwidthLimit(r string, bytesPerSecond int64) error {
+	repository, err := name.NewRepository(r)
+	if err != nil {
+		return fmt.Errorf("repo bandwidth limit : %w", err)
+	}
+	bandwidth.limiter(repository.RegistryStr()).setLimit(bytesPerSecond)
+	return nil
+}
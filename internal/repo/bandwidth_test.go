@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestByteLimiterReserve(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := &byteLimiter{}
+	if wait := l.reserve(1<<20, now); wait != 0 {
+		t.Errorf("unlimited transfer waited %s", wait)
+	}
+
+	l.setLimit(2000)
+	l.setLimit(4000)
+	if l.perSecond != 2000 {
+		t.Errorf("got limit %d, want lowest 2000", l.perSecond)
+	}
+	if wait := l.reserve(2000, now); wait != 0 {
+		t.Errorf("burst waited %s", wait)
+	}
+	if wait := l.reserve(1000, now); wait != 500*time.Millisecond {
+		t.Errorf("got wait %s, want 500ms", wait)
+	}
+	if wait := l.reserve(1000, now.Add(time.Second)); wait != 0 {
+		t.Errorf("got wait %s after refill, want none", wait)
+	}
+}
+
+func TestBandwidthTransport(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 3*bandwidthChunk)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	rt := &bandwidthTransport{
+		base:   http.DefaultTransport,
+		global: &byteLimiter{},
+		hosts:  map[string]*byteLimiter{},
+	}
+	rt.global.setLimit(8 * bandwidthChunk)
+	rt.limiter(server.Listener.Addr().String()).setLimit(2 * bandwidthChunk)
+	client := &http.Client{Transport: rt}
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != len(payload) {
+		t.Errorf("got %d bytes, want %d", len(body), len(payload))
+	}
+	// A burst of 2 chunks, then 1 more chunk at 2 chunks per second.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("transfer took %s, want host limit applied", elapsed)
+	}
+}
+
+func TestBandwidthTransportRedirect(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 3*bandwidthChunk)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer storage.Close()
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, storage.URL, http.StatusTemporaryRedirect)
+	}))
+	defer registry.Close()
+
+	rt := &bandwidthTransport{
+		base:   http.DefaultTransport,
+		global: &byteLimiter{},
+		hosts:  map[string]*byteLimiter{},
+	}
+	rt.limiter(registry.Listener.Addr().String()).setLimit(2 * bandwidthChunk)
+	client := &http.Client{Transport: rt}
+
+	// Blobs redirected to storage are throttled by the registry limit,
+	// until the request is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, registry.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("transfer took %s, want it stopped at the deadline", elapsed)
+	}
+	if _, ok := rt.hosts[storage.Listener.Addr().String()]; ok {
+		t.Errorf("got a limiter for the redirect host")
+	}
+}
+
+// recordingTransport answers every request with an empty response.
+type recordingTransport struct {
+	req *http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestBandwidthTransportGetBody(t *testing.T) {
+	base := &recordingTransport{}
+	rt := &bandwidthTransport{
+		base:   base,
+		global: &byteLimiter{},
+		hosts:  map[string]*byteLimiter{},
+	}
+	req, err := http.NewRequest(http.MethodPut, "http://registry.local/v2/app/blobs/uploads/", bytes.NewReader([]byte("blob")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replayed bodies, e.g. on redirects, are throttled too.
+	body, err := base.req.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body.(*throttledReader); !ok {
+		t.Errorf("got replayed body %T, want it throttled", body)
+	}
+	if _, ok := req.Body.(*throttledReader); ok {
+		t.Errorf("got the original request modified")
+	}
+}
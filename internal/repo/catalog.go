@@ -0,0 +1,120 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// RepositoryLister lists the repositories of a registry host. namespace
+// is the literal prefix of the wanted repositories, which listers may
+// require when the whole host cannot be listed.
+type RepositoryLister interface {
+	ListRepositories(host string, namespace string) ([]string, error)
+}
+
+var listers = map[string]RepositoryLister{}
+
+// SetRepositoryLister lists the repositories of a registry host with l
+// instead of the registry catalog.
+func SetRepositoryLister(host string, l RepositoryLister) error {
+	registry, err := name.NewRegistry(host)
+	if err != nil {
+		return fmt.Errorf("repo set lister : %w", err)
+	}
+	listers[registry.RegistryStr()] = l
+	return nil
+}
+
+// ListRepositories returns the repositories of a registry host, an empty
+// host being Docker Hub, with their namespace ("" for all of them).
+func ListRepositories(host string, namespace string) ([]string, error) {
+	registry, err := name.NewRegistry(host)
+	if err != nil {
+		return nil, fmt.Errorf("repo list repositories : %w", err)
+	}
+
+	lister, ok := listers[registry.RegistryStr()]
+	if !ok {
+		lister = CatalogLister{}
+		if registry.RegistryStr() == name.DefaultRegistry {
+			lister = &DockerHubLister{url: "https://hub.docker.com"}
+		}
+	}
+
+	repositories, err := lister.ListRepositories(registry.RegistryStr(), namespace)
+	if err != nil {
+		return nil, fmt.Errorf("repo list repositories : %w", err)
+	}
+	if namespace == "" {
+		return repositories, nil
+	}
+	inNamespace := []string{}
+	for _, repository := range repositories {
+		if strings.HasPrefix(repository, namespace+"/") {
+			inNamespace = append(inNamespace, repository)
+		}
+	}
+	return inNamespace, nil
+}
+
+// CatalogLister lists repositories through the registry _catalog endpoint.
+type CatalogLister struct{}
+
+// ListRepositories returns every repository of the catalog.
+func (CatalogLister) ListRepositories(host string, namespace string) ([]string, error) {
+	registry, err := name.NewRegistry(host)
+	if err != nil {
+		return nil, err
+	}
+	return remote.Catalog(context.Background(), registry, remoteOptions()...)
+}
+
+// DockerHubLister lists the public repositories of a Docker Hub
+// namespace through the Hub API, as Docker Hub has no catalog.
+type DockerHubLister struct {
+	url string
+}
+
+// ListRepositories returns the repositories of a namespace.
+func (l *DockerHubLister) ListRepositories(host string, namespace string) ([]string, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("listing Docker Hub repositories requires a namespace")
+	}
+
+	client := &http.Client{Transport: registryTransport}
+	repositories := []string{}
+	next := fmt.Sprintf("%s/v2/repositories/%s/?page_size=100", l.url, namespace)
+	for next != "" {
+		resp, err := client.Get(next)
+		if err != nil {
+			return nil, err
+		}
+		page := struct {
+			Next    string `json:"next"`
+			Results []struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"results"`
+		}{}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s : status code %d", next, resp.StatusCode)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range page.Results {
+			repositories = append(repositories, result.Namespace+"/"+result.Name)
+		}
+		next = page.Next
+	}
+	return repositories, nil
+}
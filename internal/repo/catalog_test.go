@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// staticLister stands in for hosts without catalog.
+type staticLister []string
+
+func (l staticLister) ListRepositories(host string, namespace string) ([]string, error) {
+	return l, nil
+}
+
+func TestListRepositories(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repository := range []string{"team/web", "team/api", "other/web"} {
+		ref, err := name.ParseReference(host + "/" + repository + ":1.0.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repositories, err := ListRepositories(host, "team")
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	sort.Strings(repositories)
+	if want := []string{"team/api", "team/web"}; !reflect.DeepEqual(repositories, want) {
+		t.Errorf("got %v, want %v", repositories, want)
+	}
+
+	if err := SetRepositoryLister("nocatalog.local", staticLister{"team/web", "other/web"}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(listers, "nocatalog.local")
+	repositories, err = ListRepositories("nocatalog.local", "")
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if want := []string{"team/web", "other/web"}; !reflect.DeepEqual(repositories, want) {
+		t.Errorf("got %v, want %v", repositories, want)
+	}
+}
+
+func TestDockerHubLister(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/repositories/bitnami/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `{"next": null, "results": [{"name": "redis", "namespace": "bitnami"}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"next": "%s/v2/repositories/bitnami/?page=2", "results": [{"name": "nginx", "namespace": "bitnami"}]}`, server.URL)
+	}))
+	defer server.Close()
+
+	lister := &DockerHubLister{url: server.URL}
+	repositories, err := lister.ListRepositories(name.DefaultRegistry, "bitnami")
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if want := []string{"bitnami/nginx", "bitnami/redis"}; !reflect.DeepEqual(repositories, want) {
+		t.Errorf("got %v, want %v", repositories, want)
+	}
+
+	if _, err := lister.ListRepositories(name.DefaultRegistry, ""); err == nil {
+		t.Errorf("got no error listing Docker Hub without namespace")
+	}
+}
@@ -0,0 +1,453 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// Destination is where synced images are written. Repositories are
+// target repository addresses, and a digest may be given instead of a tag.
+type Destination interface {
+	ListTags(r string) ([]string, error)
+	TagDigest(tag string, r string) (string, error)
+	WriteImage(tag string, r string, manifest remote.Taggable) error
+	DeleteTag(tag string, r string) error
+}
+
+var destination Destination = Registry{}
+
+// SetDestination sets where synced images are written, a registry by
+// default.
+func SetDestination(d Destination) {
+	destination = d
+}
+
+// write writes a manifest copied from a source to the destination.
+func write(tag string, target string, manifest remote.Taggable) error {
+	return destination.WriteImage(tag, target, manifest)
+}
+
+// readTarget reads a tag written to the destination, as getManifest does.
+func readTarget(target string, tag string) (remote.Taggable, v1.Descriptor, error) {
+	s, ok := destination.(Source)
+	if !ok {
+		return nil, v1.Descriptor{}, fmt.Errorf("destination can't be read")
+	}
+	return readManifest(s, target, tag)
+}
+
+// Registry writes images to remote registries.
+type Registry struct{}
+
+// WriteImage pushes an image or index, mounting blobs already pushed to
+// other repositories of the registry.
+func (Registry) WriteImage(tag string, r string, manifest remote.Taggable) error {
+	dstRef, err := name.ParseReference(reference(r, tag))
+	if err != nil {
+		return err
+	}
+	return push(dstRef, manifest)
+}
+
+// DeleteTag deletes the manifest of a tag.
+func (Registry) DeleteTag(tag string, r string) error {
+	ref, err := name.ParseReference(reference(r, tag))
+	if err != nil {
+		return fmt.Errorf("repo delete tag : %w", err)
+	}
+	err = remote.Delete(ref, remoteOptions()...)
+	if err != nil {
+		err = fmt.Errorf("repo delete tag : %w", err)
+	}
+	return err
+}
+
+// OCILayout reads and writes images of an OCI image layout directory.
+// Tags are recorded as full references in the ref name annotation of the
+// index.
+type OCILayout struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewOCILayout returns a source or destination for the layout at dir,
+// created on first write.
+func NewOCILayout(dir string) *OCILayout {
+	return &OCILayout{dir: dir}
+}
+
+// index returns the index of the layout and its entries, none when it
+// does not exist yet.
+func (l *OCILayout) index() (v1.ImageIndex, []v1.Descriptor, error) {
+	if _, err := os.Stat(filepath.Join(l.dir, "index.json")); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	p, err := layout.FromPath(l.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, nil, err
+	}
+	return idx, manifest.Manifests, nil
+}
+
+// ListTags returns the tags of a repository.
+func (l *OCILayout) ListTags(r string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, descs, err := l.index()
+	if err != nil {
+		return nil, fmt.Errorf("repo list tags : %w", err)
+	}
+	tags := []string{}
+	for _, desc := range descs {
+		if tag, ok := layoutTag(desc.Annotations[AnnotationRefName], r); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// TagDigest returns the manifest digest of a tag.
+func (l *OCILayout) TagDigest(tag string, r string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, descs, err := l.index()
+	if err != nil {
+		return "", fmt.Errorf("repo tag digest : %w", err)
+	}
+	for _, desc := range descs {
+		if t, ok := layoutTag(desc.Annotations[AnnotationRefName], r); ok && t == tag {
+			return desc.Digest.String(), nil
+		}
+	}
+	return "", fmt.Errorf("repo tag digest : %s not found in %s", reference(r, tag), l.dir)
+}
+
+// WriteImage writes an image or index with its blobs, replacing a
+// previous manifest of the tag.
+func (l *OCILayout) WriteImage(tag string, r string, manifest remote.Taggable) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, err := openLayout(l.dir)
+	if err != nil {
+		return err
+	}
+	w := &layoutWriter{path: p}
+	desc, err := w.write(manifest)
+	if err != nil {
+		return err
+	}
+
+	refName := reference(r, tag)
+	desc.Annotations = map[string]string{AnnotationRefName: refName}
+	if err := p.RemoveDescriptors(match.Annotation(AnnotationRefName, refName)); err != nil {
+		return err
+	}
+	return p.AppendDescriptor(desc)
+}
+
+// DeleteTag removes a tag, and the blobs no other tag references.
+func (l *OCILayout) DeleteTag(tag string, r string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, err := layout.FromPath(l.dir)
+	if err != nil {
+		return fmt.Errorf("repo delete tag : %w", err)
+	}
+	if err := p.RemoveDescriptors(match.Annotation(AnnotationRefName, reference(r, tag))); err != nil {
+		return fmt.Errorf("repo delete tag : %w", err)
+	}
+	unreferenced, err := p.GarbageCollect()
+	if err != nil {
+		return fmt.Errorf("repo delete tag : %w", err)
+	}
+	for _, digest := range unreferenced {
+		if err := p.RemoveBlob(digest); err != nil {
+			return fmt.Errorf("repo delete tag : %w", err)
+		}
+	}
+	return nil
+}
+
+// DockerArchive reads and writes images of a `docker save` tarball,
+// loadable with `docker load`. Archives hold single-platform images only,
+// and are rewritten on every change. Archives don't keep manifests as-is:
+// the digest of each written manifest is recorded in a file next to the
+// archive, with a ".digests" suffix.
+type DockerArchive struct {
+	mu   sync.Mutex
+	path string
+}
+
+// digests returns the manifest digests recorded by tag name.
+func (a *DockerArchive) digests() (map[string]string, error) {
+	digests := map[string]string{}
+	contents, err := ioutil.ReadFile(a.path + ".digests")
+	if os.IsNotExist(err) {
+		return digests, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &digests); err != nil {
+		return nil, fmt.Errorf("%s.digests : %w", a.path, err)
+	}
+	return digests, nil
+}
+
+// recordDigest records the manifest digest of a tag name, or forgets it
+// when digest is empty.
+func (a *DockerArchive) recordDigest(tagName string, digest string) error {
+	digests, err := a.digests()
+	if err != nil {
+		return err
+	}
+	if digest == "" {
+		delete(digests, tagName)
+	} else {
+		digests[tagName] = digest
+	}
+	contents, err := json.MarshalIndent(digests, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.path+".digests", contents, 0644)
+}
+
+// NewDockerArchive returns a source or destination for the archive at
+// path, created on first write.
+func NewDockerArchive(path string) *DockerArchive {
+	return &DockerArchive{path: path}
+}
+
+func (a *DockerArchive) opener() tarball.Opener {
+	return func() (io.ReadCloser, error) {
+		return os.Open(a.path)
+	}
+}
+
+// tags returns the tags of the archive, none when it does not exist yet.
+func (a *DockerArchive) tags() ([]name.Tag, error) {
+	if _, err := os.Stat(a.path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	manifest, err := tarball.LoadManifest(a.opener())
+	if err != nil {
+		return nil, err
+	}
+	tags := []name.Tag{}
+	for _, desc := range manifest {
+		for _, repoTag := range desc.RepoTags {
+			tag, err := name.NewTag(repoTag)
+			if err != nil {
+				return nil, err
+			}
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// find returns the archive tag of a repository tag.
+func (a *DockerArchive) find(tag string, r string) (name.Tag, bool, error) {
+	wanted, err := name.NewTag(r + ":" + tag)
+	if err != nil {
+		return name.Tag{}, false, err
+	}
+	tags, err := a.tags()
+	if err != nil {
+		return name.Tag{}, false, err
+	}
+	for _, t := range tags {
+		if t.Name() == wanted.Name() {
+			return t, true, nil
+		}
+	}
+	return wanted, false, nil
+}
+
+// ListTags returns the tags of a repository.
+func (a *DockerArchive) ListTags(r string) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	repository, err := name.NewRepository(r)
+	if err != nil {
+		return nil, fmt.Errorf("repo list tags : %w", err)
+	}
+	tags, err := a.tags()
+	if err != nil {
+		return nil, fmt.Errorf("repo list tags : %w", err)
+	}
+	repoTags := []string{}
+	for _, tag := range tags {
+		if tag.Context().Name() == repository.Name() {
+			repoTags = append(repoTags, tag.TagStr())
+		}
+	}
+	return repoTags, nil
+}
+
+// TagDigest returns the digest of the manifest written to a tag. Images
+// not written by imgsync have the digest docker computes from the archive.
+func (a *DockerArchive) TagDigest(tag string, r string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, ok, err := a.find(tag, r)
+	if err != nil {
+		return "", fmt.Errorf("repo tag digest : %w", err)
+	}
+	if !ok {
+		return "", fmt.Errorf("repo tag digest : %s not found in %s", t, a.path)
+	}
+	digests, err := a.digests()
+	if err != nil {
+		return "", fmt.Errorf("repo tag digest : %w", err)
+	}
+	if digest, ok := digests[t.Name()]; ok {
+		return digest, nil
+	}
+	img, err := tarball.Image(a.opener(), &t)
+	if err != nil {
+		return "", fmt.Errorf("repo tag digest : %w", err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return "", fmt.Errorf("repo tag digest : %w", err)
+	}
+	return digest.String(), nil
+}
+
+// WriteImage adds an image to the archive, replacing a previous image of
+// the tag. An index is written as its only image.
+func (a *DockerArchive) WriteImage(tag string, r string, manifest remote.Taggable) error {
+	if strings.Contains(tag, ":") {
+		return fmt.Errorf("docker archives only hold tagged images, not %s", tag)
+	}
+	img, err := singleImage(manifest)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, err := name.NewTag(r + ":" + tag)
+	if err != nil {
+		return err
+	}
+	desc, err := describe(manifest)
+	if err != nil {
+		return err
+	}
+	if err := a.rewrite(t.Name(), map[name.Reference]v1.Image{t: img}); err != nil {
+		return err
+	}
+	return a.recordDigest(t.Name(), desc.Digest.String())
+}
+
+// DeleteTag removes a tag from the archive.
+func (a *DockerArchive) DeleteTag(tag string, r string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, ok, err := a.find(tag, r)
+	if err != nil {
+		return fmt.Errorf("repo delete tag : %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	if err := a.rewrite(t.Name(), map[name.Reference]v1.Image{}); err != nil {
+		return fmt.Errorf("repo delete tag : %w", err)
+	}
+	if err := a.recordDigest(t.Name(), ""); err != nil {
+		return fmt.Errorf("repo delete tag : %w", err)
+	}
+	return nil
+}
+
+// rewrite writes the archive again with the images of images, and every
+// current image except the one of the removed tag name.
+func (a *DockerArchive) rewrite(removed string, images map[name.Reference]v1.Image) error {
+	tags, err := a.tags()
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if t.Name() == removed {
+			continue
+		}
+		t := t
+		img, err := tarball.Image(a.opener(), &t)
+		if err != nil {
+			return err
+		}
+		images[t] = img
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(a.path), ".imgsync-archive-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := tarball.MultiRefWriteToFile(tmp.Name(), images); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.path)
+}
+
+// singleImage returns the image of a manifest, or the only image of an
+// index.
+func singleImage(manifest remote.Taggable) (v1.Image, error) {
+	manifest, err := unwrapDescriptor(manifest)
+	if err != nil {
+		return nil, err
+	}
+	switch m := manifest.(type) {
+	case v1.Image:
+		return m, nil
+	case v1.ImageIndex:
+		indexManifest, err := m.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		images := []v1.Descriptor{}
+		for _, desc := range indexManifest.Manifests {
+			if desc.MediaType.IsImage() {
+				images = append(images, desc)
+			}
+		}
+		if len(images) != 1 {
+			return nil, fmt.Errorf("docker archives hold single-platform images, index has %d images : select one of its platforms", len(images))
+		}
+		return m.Image(images[0].Digest)
+	}
+	return nil, fmt.Errorf("docker archives only hold images")
+}
@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestDestinations(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	source := host + "/app"
+
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := random.Index(1024, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, manifest := range map[string]remote.Taggable{"1.0.0": img, "2.0.0": idx} {
+		ref, err := name.ParseReference(source + ":" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Push(ref, manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "imgsync-destination")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetDestination(Registry{})
+
+	var tests = []struct {
+		name        string
+		destination Destination
+		target      string
+	}{
+		{"registry", Registry{}, host + "/mirror/app"},
+		{"oci", NewOCILayout(filepath.Join(dir, "layout")), "mirror.local/app"},
+		{"docker-archive", NewDockerArchive(filepath.Join(dir, "images.tar")), "mirror.local/app"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetDestination(test.destination)
+			for _, tag := range []string{"1.0.0", "2.0.0"} {
+				if err := SyncTagBetweenRepos(tag, source, test.target, nil); err != nil {
+					t.Fatalf("got unexpected error %v", err)
+				}
+			}
+
+			tags, err := test.destination.ListTags(test.target)
+			if err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			if len(tags) != 2 {
+				t.Errorf("got tags %v, want 1.0.0 and 2.0.0", tags)
+			}
+
+			digest, err := test.destination.TagDigest("1.0.0", test.target)
+			if err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			sourceDigest, err := TagDigest("1.0.0", source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if digest != sourceDigest {
+				t.Errorf("got digest %s, want %s", digest, sourceDigest)
+			}
+			if _, err := test.destination.(Source).Manifest(digest, test.target); err != nil {
+				t.Errorf("got unexpected error reading %s : %v", digest, err)
+			}
+			if size, err := TagSize("1.0.0", test.target); err != nil || size <= 0 {
+				t.Errorf("got size %d, error %v", size, err)
+			}
+
+			if err := test.destination.DeleteTag("2.0.0", test.target); err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			if _, err := test.destination.TagDigest("2.0.0", test.target); err == nil {
+				t.Errorf("got 2.0.0 digest after deleting it")
+			}
+		})
+	}
+}
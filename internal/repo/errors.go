@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// ErrorClass is the kind of a registry error.
+type ErrorClass string
+
+// Error classes. Only rate-limit, network and server errors are retryable.
+const (
+	ErrorClassAuth      ErrorClass = "auth"
+	ErrorClassNotFound  ErrorClass = "not-found"
+	ErrorClassRateLimit ErrorClass = "rate-limit"
+	ErrorClassNetwork   ErrorClass = "network"
+	ErrorClassServer    ErrorClass = "server"
+	ErrorClassUnknown   ErrorClass = "unknown"
+)
+
+// Retryable tells if an error of this class may succeed when retried.
+func (c ErrorClass) Retryable() bool {
+	switch c {
+	case ErrorClassRateLimit, ErrorClassNetwork, ErrorClassServer:
+		return true
+	}
+	return false
+}
+
+// ClassifyError returns the class of an error returned by this package.
+func ClassifyError(err error) ErrorClass {
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return ErrorClassRateLimit
+	}
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return classifyTransportError(terr)
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorClassNetwork
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorClassNetwork
+	}
+
+	return ErrorClassUnknown
+}
+
+func classifyTransportError(terr *transport.Error) ErrorClass {
+	for _, diagnostic := range terr.Errors {
+		switch diagnostic.Code {
+		case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+			return ErrorClassAuth
+		case transport.NameUnknownErrorCode, transport.ManifestUnknownErrorCode, transport.BlobUnknownErrorCode:
+			return ErrorClassNotFound
+		case transport.TooManyRequestsErrorCode:
+			return ErrorClassRateLimit
+		}
+	}
+
+	switch {
+	case terr.StatusCode == http.StatusUnauthorized, terr.StatusCode == http.StatusForbidden:
+		return ErrorClassAuth
+	case terr.StatusCode == http.StatusNotFound:
+		return ErrorClassNotFound
+	case terr.StatusCode == http.StatusTooManyRequests:
+		return ErrorClassRateLimit
+	case terr.StatusCode >= http.StatusInternalServerError:
+		return ErrorClassServer
+	}
+	return ErrorClassUnknown
+}
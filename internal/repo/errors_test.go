@@ -0,0 +1,40 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+func TestClassifyError(t *testing.T) {
+	var tests = []struct {
+		err  error
+		want ErrorClass
+	}{
+		{&transport.Error{StatusCode: http.StatusUnauthorized}, ErrorClassAuth},
+		{&transport.Error{StatusCode: http.StatusNotFound}, ErrorClassNotFound},
+		{&transport.Error{StatusCode: http.StatusTooManyRequests}, ErrorClassRateLimit},
+		{&transport.Error{StatusCode: http.StatusBadGateway}, ErrorClassServer},
+		{&transport.Error{StatusCode: http.StatusBadRequest}, ErrorClassUnknown},
+		{&transport.Error{StatusCode: http.StatusBadRequest, Errors: []transport.Diagnostic{{Code: transport.TooManyRequestsErrorCode}}}, ErrorClassRateLimit},
+		{&transport.Error{StatusCode: http.StatusNotFound, Errors: []transport.Diagnostic{{Code: transport.DeniedErrorCode}}}, ErrorClassAuth},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, ErrorClassNetwork},
+		{io.ErrUnexpectedEOF, ErrorClassNetwork},
+		{ErrNoMatchingPlatform, ErrorClassUnknown},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("%v", test.err)
+		t.Run(testname, func(t *testing.T) {
+			ans := ClassifyError(fmt.Errorf("repo copy tag : %w", test.err))
+			if ans != test.want {
+				t.Errorf("got '%s', want '%s'", ans, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,593 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Annotations of the index entries of exported tags.
+const (
+	// AnnotationRefName is the full source reference of an exported tag.
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	// AnnotationSource and AnnotationTag are the source repository address
+	// and tag of an exported tag.
+	AnnotationSource = "io.imgsync.source"
+	AnnotationTag    = "io.imgsync.tag"
+	// AnnotationTarget is the target repository address the tag syncs to.
+	AnnotationTarget = "io.imgsync.target"
+)
+
+// maxManifestSize is the largest manifest read from an archive, as
+// registries enforce.
+const maxManifestSize = 4 << 20
+
+// openLayout opens the OCI image layout at dir, creating it when needed.
+func openLayout(dir string) (layout.Path, error) {
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); os.IsNotExist(err) {
+		return layout.Write(dir, empty.Index)
+	}
+	return layout.FromPath(dir)
+}
+
+// ExportTag writes a source tag, or the given digest of it, to the OCI
+// image layout at dir, replacing a previous export of the tag, and
+// records the target it syncs to. Layers in skip are left out, for
+// incremental exports. When platforms are provided, only matching images
+// are exported. It returns the size of the blobs written.
+func ExportTag(tag string, digest string, source string, target string, dir string, platforms []string, skip map[v1.Hash]bool) (int64, error) {
+	sourceRef := tag
+	if digest != "" {
+		sourceRef = digest
+	}
+	p, err := openLayout(dir)
+	if err != nil {
+		return 0, fmt.Errorf("repo export tag : %w", err)
+	}
+
+	manifest, _, err := getManifest(source, sourceRef)
+	if err != nil {
+		return 0, fmt.Errorf("repo export tag : %w", err)
+	}
+
+	if idx, ok := manifest.(v1.ImageIndex); ok {
+		if len(platforms) > 0 {
+			parsedPlatforms, err := ParsePlatforms(platforms)
+			if err != nil {
+				return 0, fmt.Errorf("repo export tag : %w", err)
+			}
+			manifest, err = filterIndexPlatforms(idx, parsedPlatforms)
+			if err != nil {
+				return 0, fmt.Errorf("repo export tag : %w", err)
+			}
+		}
+	} else if img, ok := manifest.(v1.Image); ok {
+		if len(platforms) > 0 {
+			parsedPlatforms, err := ParsePlatforms(platforms)
+			if err != nil {
+				return 0, fmt.Errorf("repo export tag : %w", err)
+			}
+			platform, err := imagePlatform(img)
+			if err != nil {
+				return 0, fmt.Errorf("repo export tag : %w", err)
+			}
+			if !platformMatches(platform, parsedPlatforms) {
+				return 0, fmt.Errorf("repo export tag : %w", ErrNoMatchingPlatform)
+			}
+		}
+	}
+
+	w := &layoutWriter{path: p, skip: skip}
+	indexDesc, err := w.write(manifest)
+	if err != nil {
+		return w.written, fmt.Errorf("repo export tag : %w", err)
+	}
+
+	refName := source + ":" + tag
+	indexDesc.Annotations = map[string]string{
+		AnnotationRefName: refName,
+		AnnotationSource:  source,
+		AnnotationTag:     tag,
+		AnnotationTarget:  target,
+	}
+	if err := p.RemoveDescriptors(match.Annotation(AnnotationRefName, refName)); err != nil {
+		return w.written, fmt.Errorf("repo export tag : %w", err)
+	}
+	if err := p.AppendDescriptor(indexDesc); err != nil {
+		return w.written, fmt.Errorf("repo export tag : %w", err)
+	}
+	return w.written, nil
+}
+
+// layoutWriter writes manifests and their blobs to an OCI image layout.
+type layoutWriter struct {
+	path layout.Path
+	skip map[v1.Hash]bool
+	// written is the size of the blobs written.
+	written int64
+}
+
+// write writes an image, index or other blob of an index, and returns
+// its descriptor.
+func (w *layoutWriter) write(manifest remote.Taggable) (v1.Descriptor, error) {
+	manifest, err := unwrapDescriptor(manifest)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	raw, err := manifest.RawManifest()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	desc := v1.Descriptor{Size: int64(len(raw))}
+	if d, ok := manifest.(*remote.Descriptor); ok {
+		desc.MediaType = d.MediaType
+	}
+	desc.Digest, _, err = v1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	switch m := manifest.(type) {
+	case v1.ImageIndex:
+		desc.MediaType, err = m.MediaType()
+		if err != nil {
+			return v1.Descriptor{}, err
+		}
+		if err := w.writeIndexChildren(m); err != nil {
+			return v1.Descriptor{}, err
+		}
+	case v1.Image:
+		desc.MediaType, err = m.MediaType()
+		if err != nil {
+			return v1.Descriptor{}, err
+		}
+		if err := w.writeImageBlobs(m); err != nil {
+			return v1.Descriptor{}, err
+		}
+	}
+
+	// Manifests are always written, so that every export can be read on
+	// its own.
+	if err := w.storeBlob(desc.Digest, desc.Size, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(raw)), nil
+	}); err != nil {
+		return v1.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+func (w *layoutWriter) writeIndexChildren(idx v1.ImageIndex) error {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, child := range manifest.Manifests {
+		switch {
+		case child.MediaType.IsIndex():
+			childIdx, err := idx.ImageIndex(child.Digest)
+			if err != nil {
+				return err
+			}
+			if _, err := w.write(childIdx); err != nil {
+				return err
+			}
+		case child.MediaType.IsImage():
+			img, err := idx.Image(child.Digest)
+			if err != nil {
+				return err
+			}
+			if _, err := w.write(img); err != nil {
+				return err
+			}
+		default:
+			// Artifacts of an index, like remote indexes expose them.
+			wl, ok := idx.(interface {
+				Layer(v1.Hash) (v1.Layer, error)
+			})
+			if !ok {
+				return fmt.Errorf("index child %s is neither an image nor an index", child.Digest)
+			}
+			layer, err := wl.Layer(child.Digest)
+			if err != nil {
+				return err
+			}
+			if err := w.writeBlob(child.Digest, child.Size, layer.Compressed); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *layoutWriter) writeImageBlobs(img v1.Image) error {
+	if layerCache != nil {
+		img = cache.Image(img, layerCache)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	// Configs are small and read eagerly by pushes: always write them.
+	if err := w.storeBlob(manifest.Config.Digest, manifest.Config.Size, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(rawConfig)), nil
+	}); err != nil {
+		return err
+	}
+
+	for _, desc := range manifest.Layers {
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return err
+		}
+		if err := w.writeBlob(desc.Digest, desc.Size, layer.Compressed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBlob writes a blob unless skipped or already in the layout.
+func (w *layoutWriter) writeBlob(digest v1.Hash, size int64, open func() (io.ReadCloser, error)) error {
+	if w.skip[digest] {
+		return nil
+	}
+	return w.storeBlob(digest, size, open)
+}
+
+// storeBlob writes a blob unless already in the layout.
+func (w *layoutWriter) storeBlob(digest v1.Hash, size int64, open func() (io.ReadCloser, error)) error {
+	if rc, err := w.path.Blob(digest); err == nil {
+		rc.Close()
+		return nil
+	}
+
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	if err := w.path.WriteBlob(digest, rc); err != nil {
+		return err
+	}
+	w.written += size
+	return nil
+}
+
+// LayoutBlobs returns the digests of every manifest and blob referenced
+// by an OCI image layout directory or archive, whether the blobs are
+// part of it or were left out of an incremental export.
+func LayoutBlobs(path string) (map[v1.Hash]bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("repo layout blobs : %w", err)
+	}
+
+	readFile := func(name string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join(path, name))
+	}
+	if !info.IsDir() {
+		files, err := readArchiveManifests(path)
+		if err != nil {
+			return nil, fmt.Errorf("repo layout blobs : %w", err)
+		}
+		readFile = func(name string) ([]byte, error) {
+			raw, ok := files[name]
+			if !ok {
+				return nil, fmt.Errorf("%s not found in %s", name, path)
+			}
+			return raw, nil
+		}
+	}
+
+	rawIndex, err := readFile("index.json")
+	if err != nil {
+		return nil, fmt.Errorf("repo layout blobs : %w", err)
+	}
+	blobs := map[v1.Hash]bool{}
+	err = addIndexBlobs(blobs, rawIndex, func(digest v1.Hash) ([]byte, error) {
+		return readFile(filepath.Join("blobs", digest.Algorithm, digest.Hex))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repo layout blobs : %w", err)
+	}
+	return blobs, nil
+}
+
+// addIndexBlobs adds the digests referenced by an index, recursively.
+func addIndexBlobs(blobs map[v1.Hash]bool, rawIndex []byte, readBlob func(v1.Hash) ([]byte, error)) error {
+	index, err := v1.ParseIndexManifest(bytes.NewReader(rawIndex))
+	if err != nil {
+		return err
+	}
+	for _, desc := range index.Manifests {
+		blobs[desc.Digest] = true
+		switch {
+		case desc.MediaType.IsIndex():
+			raw, err := readBlob(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := addIndexBlobs(blobs, raw, readBlob); err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			raw, err := readBlob(desc.Digest)
+			if err != nil {
+				return err
+			}
+			manifest, err := v1.ParseManifest(bytes.NewReader(raw))
+			if err != nil {
+				return err
+			}
+			blobs[manifest.Config.Digest] = true
+			for _, layer := range manifest.Layers {
+				blobs[layer.Digest] = true
+			}
+		}
+	}
+	return nil
+}
+
+// readArchiveManifests returns the files of a layout archive small
+// enough to be manifests, by path.
+func readArchiveManifests(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size > maxManifestSize {
+			continue
+		}
+		raw, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[filepath.Clean(hdr.Name)] = raw
+	}
+}
+
+// ArchiveLayout writes the OCI image layout at dir to a tar archive.
+func ArchiveLayout(dir string, archive string) error {
+	f, err := os.Create(archive)
+	if err != nil {
+		return fmt.Errorf("repo archive layout : %w", err)
+	}
+
+	tw := tar.NewWriter(f)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(archive)
+		return fmt.Errorf("repo archive layout : %w", err)
+	}
+	return nil
+}
+
+// LayoutTag is a tag exported to an OCI image layout.
+type LayoutTag struct {
+	Source string
+	Tag    string
+	// Target is the target repository address recorded at export.
+	Target string
+	Digest v1.Hash
+}
+
+// LayoutTags returns the tags of an OCI image layout exported by ExportTag.
+// Entries without source annotations are ignored.
+func LayoutTags(dir string) ([]LayoutTag, error) {
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("repo layout tags : %w", err)
+	}
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("repo layout tags : %w", err)
+	}
+	index, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("repo layout tags : %w", err)
+	}
+
+	tags := []LayoutTag{}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[AnnotationSource] == "" || desc.Annotations[AnnotationTag] == "" {
+			continue
+		}
+		tags = append(tags, LayoutTag{
+			Source: desc.Annotations[AnnotationSource],
+			Tag:    desc.Annotations[AnnotationTag],
+			Target: desc.Annotations[AnnotationTarget],
+			Digest: desc.Digest,
+		})
+	}
+	return tags, nil
+}
+
+// VerifyLayout checks the digest of every blob of an OCI image layout,
+// and returns the number of blobs verified.
+func VerifyLayout(dir string) (int, error) {
+	verified := 0
+	blobsDir := filepath.Join(dir, "blobs")
+	err := filepath.Walk(blobsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(blobsDir, path)
+		if err != nil {
+			return err
+		}
+		digest, err := v1.NewHash(strings.Replace(filepath.ToSlash(rel), "/", ":", 1))
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		actual, _, err := v1.SHA256(f)
+		if err != nil {
+			return err
+		}
+		if actual != digest {
+			return fmt.Errorf("blob %s has digest %s", digest, actual)
+		}
+		verified++
+		return nil
+	})
+	if err != nil {
+		return verified, fmt.Errorf("repo verify layout : %w", err)
+	}
+	return verified, nil
+}
+
+// ImportTag writes a manifest of the OCI image layout at dir to a target
+// tag. Layers left out of incremental exports must already be in target.
+func ImportTag(dir string, digest v1.Hash, tag string, target string) error {
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		return fmt.Errorf("repo import tag : %w", err)
+	}
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return fmt.Errorf("repo import tag : %w", err)
+	}
+	index, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("repo import tag : %w", err)
+	}
+
+	var manifest remote.Taggable
+	for _, desc := range index.Manifests {
+		if desc.Digest != digest {
+			continue
+		}
+		if desc.MediaType.IsIndex() {
+			manifest, err = idx.ImageIndex(digest)
+		} else {
+			manifest, err = idx.Image(digest)
+		}
+		break
+	}
+	if err != nil {
+		return fmt.Errorf("repo import tag : %w", err)
+	}
+	if manifest == nil {
+		return fmt.Errorf("repo import tag : manifest %s not found in %s", digest, dir)
+	}
+
+	err = write(tag, target, manifest)
+	if err != nil {
+		err = fmt.Errorf("repo import tag : %w", err)
+	}
+	return err
+}
+
+// ExtractLayout extracts a layout archive written by ArchiveLayout to dir.
+func ExtractLayout(archive string, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("repo extract layout : %w", err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("repo extract layout : %w", err)
+		}
+
+		path := filepath.Join(dir, filepath.Clean(hdr.Name))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("repo extract layout : invalid path %s", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = extractFile(tr, path)
+		}
+		if err != nil {
+			return fmt.Errorf("repo extract layout : %w", err)
+		}
+	}
+}
+
+func extractFile(r io.Reader, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
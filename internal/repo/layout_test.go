@@ -0,0 +1,305 @@
+package repo
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestExportTag(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	source := strings.TrimPrefix(server.URL, "http://") + "/app"
+
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra, err := random.Layer(1024, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := mutate.AppendLayers(base, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, img := range map[string]v1.Image{"1.0.0": base, "1.1.0": next} {
+		ref, err := name.ParseReference(source + ":" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "imgsync-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	full := filepath.Join(dir, "full")
+	if _, err := ExportTag("1.0.0", "", source, "mirror/app", full, nil, nil); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	p, err := layout.FromPath(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := p.ImageIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 {
+		t.Fatalf("got %d exported manifests, want 1", len(index.Manifests))
+	}
+	annotations := index.Manifests[0].Annotations
+	if annotations[AnnotationRefName] != source+":1.0.0" || annotations[AnnotationSource] != source || annotations[AnnotationTag] != "1.0.0" || annotations[AnnotationTarget] != "mirror/app" {
+		t.Errorf("got annotations %v, want source reference", annotations)
+	}
+
+	archive := filepath.Join(dir, "full.tar")
+	if err := ArchiveLayout(full, archive); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	skip, err := LayoutBlobs(archive)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	// Manifest, config and 2 layers.
+	if len(skip) != 4 {
+		t.Errorf("got %d blobs in previous export, want 4", len(skip))
+	}
+
+	manifest, err := next.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Configs are exported even when already in a previous export.
+	skip[manifest.Config.Digest] = true
+
+	incremental := filepath.Join(dir, "incremental")
+	written, err := ExportTag("1.1.0", "", source, "mirror/app", incremental, nil, skip)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	manifestSize, err := next.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := manifestSize + manifest.Config.Size + manifest.Layers[2].Size
+	if written != want {
+		t.Errorf("got %d bytes written, want %d for the new manifest, config and layer", written, want)
+	}
+	for _, layer := range manifest.Layers[:2] {
+		if _, err := os.Stat(filepath.Join(incremental, "blobs", "sha256", layer.Digest.Hex)); !os.IsNotExist(err) {
+			t.Errorf("got layer %s exported again", layer.Digest)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(incremental, "blobs", "sha256", manifest.Config.Digest.Hex)); err != nil {
+		t.Errorf("got config not exported : %v", err)
+	}
+}
+
+func TestImportTag(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	idx, err := random.Index(1024, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(host + "/app:1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(ref, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "imgsync-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := ExportTag("1.0.0", "", host+"/app", host+"/mirror/app", dir, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := VerifyLayout(dir)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	// Index, 2 manifests, 2 configs and 4 layers.
+	if verified != 9 {
+		t.Errorf("got %d blobs verified, want 9", verified)
+	}
+
+	tags, err := LayoutTags(dir)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if len(tags) != 1 || tags[0].Tag != "1.0.0" || tags[0].Target != host+"/mirror/app" {
+		t.Fatalf("got layout tags %v, want 1.0.0 to mirror/app", tags)
+	}
+	if err := ImportTag(dir, tags[0].Digest, tags[0].Tag, tags[0].Target); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	digest, err := TagDigest("1.0.0", host+"/mirror/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != tags[0].Digest.String() {
+		t.Errorf("got imported digest %s, want %s", digest, tags[0].Digest)
+	}
+
+	blob := filepath.Join(dir, "blobs", tags[0].Digest.Algorithm, tags[0].Digest.Hex)
+	if err := ioutil.WriteFile(blob, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyLayout(dir); err == nil {
+		t.Errorf("got no error verifying a tampered blob")
+	}
+}
+
+func TestImportIncrementalTag(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra, err := random.Layer(1024, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := mutate.AppendLayers(base, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, img := range map[string]v1.Image{"1.0.0": base, "1.1.0": next} {
+		ref, err := name.ParseReference(host + "/app:" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "imgsync-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	full := filepath.Join(dir, "full")
+	if _, err := ExportTag("1.0.0", "", host+"/app", host+"/mirror/app", full, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	skip, err := LayoutBlobs(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	incremental := filepath.Join(dir, "incremental")
+	if _, err := ExportTag("1.1.0", "", host+"/app", host+"/mirror/app", incremental, nil, skip); err != nil {
+		t.Fatal(err)
+	}
+
+	// The incremental export is imported after the export it builds on.
+	for _, path := range []string{full, incremental} {
+		tags, err := LayoutTags(path)
+		if err != nil {
+			t.Fatalf("got unexpected error %v", err)
+		}
+		if len(tags) != 1 {
+			t.Fatalf("got layout tags %v, want 1", tags)
+		}
+		if err := ImportTag(path, tags[0].Digest, tags[0].Tag, tags[0].Target); err != nil {
+			t.Fatalf("got unexpected error importing %s : %v", tags[0].Tag, err)
+		}
+		digest, err := TagDigest(tags[0].Tag, host+"/mirror/app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != tags[0].Digest.String() {
+			t.Errorf("got imported digest %s, want %s", digest, tags[0].Digest)
+		}
+	}
+
+	ref, err := name.ParseReference(host + "/mirror/app:1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := remote.Image(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range layers {
+		rc, err := layer.Compressed()
+		if err != nil {
+			t.Fatalf("got imported layer missing : %v", err)
+		}
+		rc.Close()
+	}
+}
+
+func TestExtractLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgsync-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := layout.Write(filepath.Join(dir, "layout"), empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AppendImage(img); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "layout.tar")
+	if err := ArchiveLayout(filepath.Join(dir, "layout"), archive); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	extracted := filepath.Join(dir, "extracted")
+	if err := ExtractLayout(archive, extracted); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	verified, err := VerifyLayout(extracted)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if verified != 3 {
+		t.Errorf("got %d blobs extracted, want manifest, config and layer", verified)
+	}
+}
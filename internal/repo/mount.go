@@ -0,0 +1,305 @@
+package repo
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// blobIndex tracks the blobs pushed to target registries, so that other
+// repositories of the same registry mount them instead of uploading them.
+type blobIndex struct {
+	mu sync.Mutex
+	// repos maps a registry and a blob digest to a repository holding it.
+	repos map[string]map[v1.Hash]name.Repository
+	sizes map[v1.Hash]int64
+	// mounted blobs and bytes, counted once per repository and blob.
+	mounted map[string]int64
+}
+
+var targetBlobs = &blobIndex{
+	repos:   map[string]map[v1.Hash]name.Repository{},
+	sizes:   map[v1.Hash]int64{},
+	mounted: map[string]int64{},
+}
+
+// reset forgets the pushed and mounted blobs, as the target of a reloaded
+// config may be another registry.
+func (b *blobIndex) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.repos = map[string]map[v1.Hash]name.Repository{}
+	b.sizes = map[v1.Hash]int64{}
+	b.mounted = map[string]int64{}
+}
+
+func (b *blobIndex) add(repo name.Repository, digest v1.Hash, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	registry := repo.RegistryStr()
+	if _, ok := b.repos[registry]; !ok {
+		b.repos[registry] = map[v1.Hash]name.Repository{}
+	}
+	b.repos[registry][digest] = repo
+	b.sizes[digest] = size
+}
+
+// source returns another repository of the registry holding a blob.
+func (b *blobIndex) source(repo name.Repository, digest v1.Hash) (name.Repository, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	from, ok := b.repos[repo.RegistryStr()][digest]
+	if !ok || from.String() == repo.String() {
+		return name.Repository{}, false
+	}
+	return from, true
+}
+
+func (b *blobIndex) addMounted(repo string, digest string) {
+	h, err := v1.NewHash(digest)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mounted[repo+"@"+digest] = b.sizes[h]
+}
+
+// MountedBlobs returns the number and total size of blobs mounted from
+// another target repository instead of being uploaded.
+func MountedBlobs() (int, int64) {
+	targetBlobs.mu.Lock()
+	defer targetBlobs.mu.Unlock()
+
+	size := int64(0)
+	for _, s := range targetBlobs.mounted {
+		size += s
+	}
+	return len(targetBlobs.mounted), size
+}
+
+// recordBlobs adds the layers and config of a pushed image, or of every
+// image of a pushed index, to the target blob index.
+func recordBlobs(repo name.Repository, manifest remote.Taggable) error {
+	switch m := manifest.(type) {
+	case v1.ImageIndex:
+		indexManifest, err := m.IndexManifest()
+		if err != nil {
+			return err
+		}
+		for _, desc := range indexManifest.Manifests {
+			var child remote.Taggable
+			var err error
+			switch {
+			case desc.MediaType.IsImage():
+				child, err = m.Image(desc.Digest)
+			case desc.MediaType.IsIndex():
+				child, err = m.ImageIndex(desc.Digest)
+			default:
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := recordBlobs(repo, child); err != nil {
+				return err
+			}
+		}
+	case v1.Image:
+		imageManifest, err := m.Manifest()
+		if err != nil {
+			return err
+		}
+		targetBlobs.add(repo, imageManifest.Config.Digest, imageManifest.Config.Size)
+		for _, layer := range imageManifest.Layers {
+			targetBlobs.add(repo, layer.Digest, layer.Size)
+		}
+	}
+	return nil
+}
+
+var layerCache cache.Cache
+
+// SetLayerCache makes pushes read source layers from c, and store the
+// layers they pull into it.
+func SetLayerCache(c cache.Cache) {
+	layerCache = c
+}
+
+// newMountableImage wraps an image pushed to target, reading its layers
+// from the layer cache when set.
+func newMountableImage(img v1.Image, target name.Repository, progress *layerProgress) *mountableImage {
+	if layerCache != nil {
+		img = cache.Image(img, layerCache)
+	}
+	return &mountableImage{Image: img, target: target, progress: progress}
+}
+
+// mountableImage makes layers already pushed to another repository of
+// the target registry mountable from there, and counts them in the push
+// progress.
+type mountableImage struct {
+	v1.Image
+	target   name.Repository
+	progress *layerProgress
+}
+
+func (i *mountableImage) mountable(layer v1.Layer) (v1.Layer, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	from, ok := targetBlobs.source(i.target, digest)
+	if !ok {
+		return layer, nil
+	}
+	return &remote.MountableLayer{Layer: layer, Reference: from.Digest(digest.String())}, nil
+}
+
+func (i *mountableImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if err := i.progress.add(layers); err != nil {
+		return nil, err
+	}
+	mountable := make([]v1.Layer, 0, len(layers))
+	for _, layer := range layers {
+		l, err := i.mountable(layer)
+		if err != nil {
+			return nil, err
+		}
+		mountable = append(mountable, l)
+	}
+	return mountable, nil
+}
+
+func (i *mountableImage) LayerByDigest(digest v1.Hash) (v1.Layer, error) {
+	layer, err := i.Image.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	return i.mountable(layer)
+}
+
+// imageIndex lets mountableIndex embed a v1.ImageIndex while overriding
+// its ImageIndex method.
+type imageIndex = v1.ImageIndex
+
+// mountableIndex is an index of mountable images.
+type mountableIndex struct {
+	imageIndex
+	target   name.Repository
+	progress *layerProgress
+}
+
+func (i *mountableIndex) Image(digest v1.Hash) (v1.Image, error) {
+	img, err := i.imageIndex.Image(digest)
+	if err != nil {
+		return nil, err
+	}
+	return newMountableImage(img, i.target, i.progress), nil
+}
+
+func (i *mountableIndex) ImageIndex(digest v1.Hash) (v1.ImageIndex, error) {
+	idx, err := i.imageIndex.ImageIndex(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &mountableIndex{imageIndex: idx, target: i.target, progress: i.progress}, nil
+}
+
+// Layer returns non-image children, e.g. artifacts, like remote indexes do.
+func (i *mountableIndex) Layer(digest v1.Hash) (v1.Layer, error) {
+	wl, ok := i.imageIndex.(interface {
+		Layer(v1.Hash) (v1.Layer, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("index child %s is neither an image nor an index", digest)
+	}
+	return wl.Layer(digest)
+}
+
+// unwrapDescriptor returns the image or index of a fetched descriptor,
+// other manifests as-is.
+func unwrapDescriptor(manifest remote.Taggable) (remote.Taggable, error) {
+	desc, ok := manifest.(*remote.Descriptor)
+	if !ok {
+		return manifest, nil
+	}
+	switch {
+	case desc.MediaType.IsIndex():
+		return desc.ImageIndex()
+	case desc.MediaType.IsImage():
+		return desc.Image()
+	}
+	return manifest, nil
+}
+
+// push writes an image or index to a target reference, mounting blobs
+// already pushed to other repositories of the registry during this run.
+func push(dstRef name.Reference, manifest remote.Taggable) error {
+	target := dstRef.Context()
+	manifest, err := unwrapDescriptor(manifest)
+	if err != nil {
+		return err
+	}
+	progress, done := newLayerProgress(target)
+	defer done()
+	switch m := manifest.(type) {
+	case v1.ImageIndex:
+		manifest = &mountableIndex{imageIndex: m, target: target, progress: progress}
+	case v1.Image:
+		manifest = newMountableImage(m, target, progress)
+	}
+
+	options, wait := writeOptions(dstRef.String(), progress)
+	err = remote.Push(dstRef, manifest, options...)
+	wait()
+	if err != nil {
+		return err
+	}
+	return recordBlobs(target, manifest)
+}
+
+// mountTransport counts blob mounts accepted by registries, and reports
+// blobs found, mounted or uploaded to the push progress.
+type mountTransport struct {
+	base http.RoundTripper
+}
+
+func (t *mountTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	query := req.URL.Query()
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	i := strings.LastIndex(path, "/blobs/")
+	if !strings.HasPrefix(req.URL.Path, "/v2/") || i < 0 {
+		return resp, nil
+	}
+	repo := req.URL.Host + "/" + path[:i]
+	switch {
+	case req.Method == http.MethodPost && query.Get("mount") != "" && resp.StatusCode == http.StatusCreated:
+		targetBlobs.addMounted(repo, query.Get("mount"))
+		blobPushed(repo, query.Get("mount"))
+	case req.Method == http.MethodHead && resp.StatusCode == http.StatusOK:
+		blobPushed(repo, path[i+len("/blobs/"):])
+	case req.Method == http.MethodPut && query.Get("digest") != "" && resp.StatusCode == http.StatusCreated:
+		blobPushed(repo, query.Get("digest"))
+	}
+	return resp, nil
+}
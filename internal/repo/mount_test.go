@@ -0,0 +1,117 @@
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestPushMountsBlobs(t *testing.T) {
+	// The in-memory registry shares blobs between repositories: make the
+	// second repository miss them, and accept mounts from the first one.
+	reg := registry.New()
+	mountRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/second/blobs/sha256:") && r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query := r.URL.Query()
+		if r.URL.Path == "/v2/second/blobs/uploads/" && query.Get("mount") != "" {
+			mountRequests++
+			if query.Get("from") != "first" {
+				t.Errorf("got mount from '%s', want first", query.Get("from"))
+			}
+			w.Header().Set("Location", "/v2/second/blobs/"+query.Get("mount"))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, size := MountedBlobs()
+	for _, repo := range []string{"/first:1.0.0", "/second:1.0.0"} {
+		ref, err := name.ParseReference(host + repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := push(ref, img); err != nil {
+			t.Fatalf("got unexpected error %v", err)
+		}
+	}
+
+	mountedCount, mountedSize := MountedBlobs()
+	// Configs are small and uploaded again.
+	if mountRequests != 3 {
+		t.Errorf("got %d mount requests, want 3", mountRequests)
+	}
+	if mountedCount-count != 3 {
+		t.Errorf("got %d blobs mounted, want 3 layers", mountedCount-count)
+	}
+	if mountedSize-size < 3*1024 {
+		t.Errorf("got %d bytes mounted, want at least the layers size", mountedSize-size)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerDigest, err := layers[0].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := name.NewRepository(host + "/third")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Reset()
+	if _, ok := targetBlobs.source(third, layerDigest); ok {
+		t.Errorf("got a mount source after Reset, want none")
+	}
+	if mountedCount, _ := MountedBlobs(); mountedCount != 0 {
+		t.Errorf("got %d blobs mounted after Reset, want 0", mountedCount)
+	}
+}
+
+func TestPushProgress(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/app:1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := random.Index(1024, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := []Progress{}
+	SetProgressHandler(func(progress Progress) {
+		updates = append(updates, progress)
+	})
+	defer SetProgressHandler(nil)
+
+	if err := push(ref, idx); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if len(updates) < 2 || !updates[len(updates)-1].Done {
+		t.Fatalf("got updates %v, want a last done update", updates)
+	}
+	// The manifests are pushed once every layer is.
+	last := updates[len(updates)-2]
+	if last.Layers != 4 || last.LayersDone != 4 {
+		t.Errorf("got %d/%d layers done before the last update, want 4/4", last.LayersDone, last.Layers)
+	}
+}
@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ErrNoMatchingPlatform is returned when a source tag does not provide
+// any of the requested platforms. Such tags are expected to be skipped.
+var ErrNoMatchingPlatform = errors.New("no matching platform")
+
+func remoteOptions() []remote.Option {
+	return []remote.Option{
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithTransport(registryTransport),
+	}
+}
+
+func craneOptions() []crane.Option {
+	return []crane.Option{
+		crane.WithAuthFromKeychain(authn.DefaultKeychain),
+		crane.WithTransport(registryTransport),
+	}
+}
+
+// ParsePlatforms parses a list of "os/arch[/variant]" platform strings.
+func ParsePlatforms(platforms []string) ([]v1.Platform, error) {
+	parsed := []v1.Platform{}
+	for _, p := range platforms {
+		platform, err := v1.ParsePlatform(p)
+		if err != nil {
+			return []v1.Platform{}, fmt.Errorf("parsing platform \"%s\" : %w", p, err)
+		}
+		parsed = append(parsed, *platform)
+	}
+	return parsed, nil
+}
+
+func platformMatches(p *v1.Platform, platforms []v1.Platform) bool {
+	if p == nil {
+		return false
+	}
+	for _, spec := range platforms {
+		if p.Satisfies(spec) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIndexPlatforms removes every manifest of an index that does not
+// match any of the requested platforms.
+func filterIndexPlatforms(idx v1.ImageIndex, platforms []v1.Platform) (v1.ImageIndex, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	kept := 0
+	for _, desc := range manifest.Manifests {
+		if platformMatches(desc.Platform, platforms) {
+			kept++
+		}
+	}
+	if kept == 0 {
+		return nil, ErrNoMatchingPlatform
+	}
+
+	return mutate.RemoveManifests(idx, func(desc v1.Descriptor) bool {
+		return !platformMatches(desc.Platform, platforms)
+	}), nil
+}
+
+func imagePlatform(img v1.Image) (*v1.Platform, error) {
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Platform{
+		OS:           cf.OS,
+		Architecture: cf.Architecture,
+		Variant:      cf.Variant,
+		OSVersion:    cf.OSVersion,
+	}, nil
+}
+
+// copyPlatforms copies a source tag to a target tag keeping only the
+// requested platforms.
+// Image indexes are rewritten before being pushed, single-arch images
+// are pushed as-is or rejected with ErrNoMatchingPlatform.
+func copyPlatforms(source string, sourceTag string, tag string, target string, platforms []v1.Platform) error {
+	manifest, _, err := getManifest(source, sourceTag)
+	if err != nil {
+		return err
+	}
+
+	switch m := manifest.(type) {
+	case v1.ImageIndex:
+		filtered, err := filterIndexPlatforms(m, platforms)
+		if err != nil {
+			return err
+		}
+		return write(tag, target, filtered)
+	case v1.Image:
+		p, err := imagePlatform(m)
+		if err != nil {
+			return err
+		}
+		if !platformMatches(p, platforms) {
+			return fmt.Errorf("%w : image platform is \"%s\"", ErrNoMatchingPlatform, p.String())
+		}
+		return write(tag, target, m)
+	}
+	return fmt.Errorf("%s is neither an image nor an index", reference(source, sourceTag))
+}
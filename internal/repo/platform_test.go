@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// platformImage returns a random image with a platform in its config.
+func platformImage(t *testing.T, platform string) v1.Image {
+	t.Helper()
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := v1.ParsePlatform(platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf.OS, cf.Architecture, cf.Variant = p.OS, p.Architecture, p.Variant
+	img, err = mutate.ConfigFile(img, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestSyncTagBetweenReposPlatforms(t *testing.T) {
+	host := newTestRegistry(t)
+	source, target := host+"/app", host+"/mirror/app"
+
+	pushTestManifest(t, source+":1.0", platformIndex(t, "linux/amd64", "linux/arm64", "linux/arm/v7"))
+	img := platformImage(t, "linux/arm64")
+	pushTestManifest(t, source+":2.0", img)
+
+	if err := SyncTagBetweenRepos("1.0", source, target, []string{"linux/amd64", "linux/arm/v7"}); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	ref, err := name.ParseReference(target + ":1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := remote.Index(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, desc := range manifest.Manifests {
+		got = append(got, desc.Platform.String())
+	}
+	if len(got) != 2 || got[0] != "linux/amd64" || got[1] != "linux/arm/v7" {
+		t.Errorf("got platforms %v, want linux/amd64 and linux/arm/v7", got)
+	}
+	for _, desc := range manifest.Manifests {
+		if _, err := idx.Image(desc.Digest); err != nil {
+			t.Errorf("got platform %s not pushed : %v", desc.Platform, err)
+		}
+	}
+
+	var tests = []struct {
+		name      string
+		tag       string
+		platforms []string
+		want      error
+	}{
+		{"matching single-arch image", "2.0", []string{"linux/arm64"}, nil},
+		{"non-matching single-arch image", "2.0", []string{"linux/amd64"}, ErrNoMatchingPlatform},
+		{"non-matching index", "1.0", []string{"windows/amd64"}, ErrNoMatchingPlatform},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := SyncTagBetweenRepos(test.tag, source, host+"/other/app", test.platforms)
+			if !errors.Is(err, test.want) {
+				t.Errorf("got error %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	digest, err := TagDigest("2.0", host+"/other/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := img.Digest(); digest != want.String() {
+		t.Errorf("got single-arch digest %s, want the image pushed as-is %s", digest, want)
+	}
+}
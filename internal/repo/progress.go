@@ -0,0 +1,164 @@
+package repo
+
+import (
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Progress is a transfer progress update of an image pushed to a target.
+type Progress struct {
+	// Ref is the target reference being written.
+	Ref string
+	// Total and Complete are in bytes.
+	Total    int64
+	Complete int64
+	// Layers and LayersDone count the layers of the pushed manifests.
+	Layers     int
+	LayersDone int
+	// Done is set on the last update of a transfer, with Err on failure.
+	Done bool
+	Err  error
+}
+
+var progressHandler func(Progress)
+
+// SetProgressHandler registers a function called with progress updates of
+// every image and index pushed to a target.
+func SetProgressHandler(handler func(Progress)) {
+	progressHandler = handler
+}
+
+// layerProgress tracks which layers of a push are in the target.
+type layerProgress struct {
+	mu     sync.Mutex
+	target name.Repository
+	// layers maps the layers pushed to whether they are in the target.
+	layers map[v1.Hash]bool
+}
+
+// activeLayers are the layer progresses of pushes in progress.
+var activeLayers = struct {
+	mu     sync.Mutex
+	pushes map[*layerProgress]bool
+}{pushes: map[*layerProgress]bool{}}
+
+// newLayerProgress tracks the layers of a push to target until done is
+// called.
+func newLayerProgress(target name.Repository) (*layerProgress, func()) {
+	l := &layerProgress{target: target, layers: map[v1.Hash]bool{}}
+	activeLayers.mu.Lock()
+	activeLayers.pushes[l] = true
+	activeLayers.mu.Unlock()
+	return l, func() {
+		activeLayers.mu.Lock()
+		delete(activeLayers.pushes, l)
+		activeLayers.mu.Unlock()
+	}
+}
+
+// add counts the layers of an image pushed.
+func (l *layerProgress) add(layers []v1.Layer) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		if _, ok := l.layers[digest]; !ok {
+			l.layers[digest] = false
+		}
+	}
+	return nil
+}
+
+func (l *layerProgress) counts() (int, int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	done := 0
+	for _, ok := range l.layers {
+		if ok {
+			done++
+		}
+	}
+	return len(l.layers), done
+}
+
+// blobPushed marks a blob of a target repository, named with its
+// registry, as being in the target for the pushes of its layers.
+func blobPushed(repo string, digest string) {
+	h, err := v1.NewHash(digest)
+	if err != nil {
+		return
+	}
+
+	activeLayers.mu.Lock()
+	defer activeLayers.mu.Unlock()
+	for l := range activeLayers.pushes {
+		if l.target.Name() != repo {
+			continue
+		}
+		l.mu.Lock()
+		if _, ok := l.layers[h]; ok {
+			l.layers[h] = true
+		}
+		l.mu.Unlock()
+	}
+}
+
+// progressUpdates returns a channel of updates forwarded to the progress
+// handler, nil without handler. The channel is closed by the writer, then
+// wait returns once every update has been handled.
+func progressUpdates(ref string, layers *layerProgress) (chan v1.Update, func()) {
+	if progressHandler == nil {
+		return nil, func() {}
+	}
+
+	handler := progressHandler
+	updates := make(chan v1.Update, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		last := Progress{Ref: ref}
+		for update := range updates {
+			if update.Error != nil {
+				last.Err = update.Error
+				continue
+			}
+			last.Total = update.Total
+			last.Complete = update.Complete
+			last.Layers, last.LayersDone = layers.counts()
+			handler(last)
+		}
+		last.Layers, last.LayersDone = layers.counts()
+		if last.Err == nil {
+			last.Complete = last.Total
+			last.LayersDone = last.Layers
+		}
+		last.Done = true
+		handler(last)
+	}()
+	return updates, func() { <-done }
+}
+
+// writeOptions are remoteOptions reporting progress of writes to ref.
+// wait must be called after the write.
+func writeOptions(ref string, layers *layerProgress) ([]remote.Option, func()) {
+	options := remoteOptions()
+	updates, wait := progressUpdates(ref, layers)
+	if updates != nil {
+		options = append(options, remote.WithProgress(updates))
+	}
+	return options, wait
+}
@@ -0,0 +1,260 @@
+package repo
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// RateLimitError is returned when a registry host rate limits imgsync, or
+// when its remaining request budget would only reset after the maximum
+// wait set with SetRateLimitMaxWait.
+type RateLimitError struct {
+	Host string
+	// Reset is when the host accepts requests again, zero when unknown.
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Reset.IsZero() {
+		return fmt.Sprintf("%s rate limit exceeded", e.Host)
+	}
+	return fmt.Sprintf("%s rate limit exceeded until %s", e.Host, e.Reset.Format(time.RFC3339))
+}
+
+// hostLimiter throttles requests to a registry host: a token bucket
+// refilled at the configured rate, and a pause until the host budget resets.
+type hostLimiter struct {
+	mu sync.Mutex
+	// perMinute is the token bucket rate, 0 for no throttling.
+	perMinute int
+	tokens    float64
+	last      time.Time
+	// blockedUntil is when the host budget resets once exhausted.
+	blockedUntil time.Time
+}
+
+// reserve takes a token and returns how long to wait before the request.
+func (l *hostLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	wait := time.Duration(0)
+	if l.blockedUntil.After(now) {
+		wait = l.blockedUntil.Sub(now)
+	}
+	if l.perMinute <= 0 {
+		return wait
+	}
+
+	capacity := float64(l.perMinute)
+	if l.last.IsZero() {
+		l.tokens = capacity
+	} else {
+		l.tokens += now.Sub(l.last).Minutes() * capacity
+		if l.tokens > capacity {
+			l.tokens = capacity
+		}
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens < 0 {
+		bucketWait := time.Duration(-l.tokens / capacity * float64(time.Minute))
+		if bucketWait > wait {
+			wait = bucketWait
+		}
+	}
+	return wait
+}
+
+func (l *hostLimiter) block(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+// rateLimitTransport applies host limiters to registry requests and reads
+// rate limit headers of their responses. Every request takes a token,
+// while registries like Docker Hub only count manifest GETs: tag digests
+// and artifact lookups of single-arch tags use HEAD requests, reading
+// platforms, sizes and signatures needs the manifests themselves.
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	mu      sync.Mutex
+	hosts   map[string]*hostLimiter
+	maxWait time.Duration
+}
+
+var bandwidth = &bandwidthTransport{
+	base:   remote.DefaultTransport,
+	global: &byteLimiter{},
+	hosts:  map[string]*byteLimiter{},
+}
+
+var mounts = &mountTransport{base: bandwidth}
+
+// defaultRateLimitMaxWait is how long requests may be paused by default.
+const defaultRateLimitMaxWait = 5 * time.Minute
+
+// registryTransport is shared by every registry call, so that limits
+// apply across concurrent transfers.
+var registryTransport = &rateLimitTransport{
+	base:    mounts,
+	hosts:   map[string]*hostLimiter{},
+	maxWait: defaultRateLimitMaxWait,
+}
+
+func (t *rateLimitTransport) limiter(host string) *hostLimiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.hosts[host]
+	if !ok {
+		l = &hostLimiter{}
+		t.hosts[host] = l
+	}
+	return l
+}
+
+// resetLimits drops the configured rates. Pauses until a host budget
+// resets are kept, as they come from the hosts themselves.
+func (t *rateLimitTransport) resetLimits() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.maxWait = defaultRateLimitMaxWait
+	for _, l := range t.hosts {
+		l.mu.Lock()
+		l.perMinute, l.tokens, l.last = 0, 0, time.Time{}
+		l.mu.Unlock()
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := registryHost(req)
+	l := t.limiter(host)
+
+	t.mu.Lock()
+	maxWait := t.maxWait
+	t.mu.Unlock()
+
+	now := time.Now()
+	if wait := l.reserve(now); wait > 0 {
+		if wait > maxWait {
+			return nil, &RateLimitError{Host: host, Reset: now.Add(wait)}
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	now = time.Now()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		reset := retryAfter(resp.Header, now)
+		if !reset.IsZero() {
+			l.block(reset)
+		}
+		resp.Body.Close()
+		return nil, &RateLimitError{Host: host, Reset: reset}
+	}
+	if remaining, ok := rateLimitRemaining(resp.Header); ok && remaining <= 0 {
+		if reset := rateLimitReset(resp.Header, now); !reset.IsZero() {
+			l.block(reset)
+		}
+	}
+
+	return resp, nil
+}
+
+// rateLimitRemaining parses a "RateLimit-Remaining: 76;w=21600" header.
+func rateLimitRemaining(header http.Header) (int, bool) {
+	value := header.Get("RateLimit-Remaining")
+	if value == "" {
+		return 0, false
+	}
+	remaining, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(value, ";", 2)[0]))
+	if err != nil {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// rateLimitReset parses a "RateLimit-Reset" header, in seconds.
+func rateLimitReset(header http.Header, now time.Time) time.Time {
+	seconds, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(header.Get("RateLimit-Reset"), ";", 2)[0]))
+	if err != nil || seconds < 0 {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
+
+// retryAfter parses a "Retry-After" header, in seconds or as a date.
+func retryAfter(header http.Header, now time.Time) time.Time {
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date
+	}
+	return rateLimitReset(header, now)
+}
+
+// SetHostRequestsPerMinute throttles requests to the registry host of a
+// repository address. The lowest rate set for a host wins.
+func SetHostRequestsPerMinute(r string, requestsPerMinute int) error {
+	repository, err := name.NewRepository(r)
+	if err != nil {
+		return fmt.Errorf("repo requests per minute : %w", err)
+	}
+
+	l := registryTransport.limiter(repository.RegistryStr())
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perMinute <= 0 || requestsPerMinute < l.perMinute {
+		l.perMinute = requestsPerMinute
+	}
+	return nil
+}
+
+// SetRateLimitMaxWait sets how long requests may be paused for a host
+// budget to reset before failing with a RateLimitError.
+func SetRateLimitMaxWait(wait time.Duration) {
+	registryTransport.mu.Lock()
+	defer registryTransport.mu.Unlock()
+
+	registryTransport.maxWait = wait
+}
+
+// HostRateLimitReset returns when the rate limit budget of the registry
+// host of a repository address resets, zero when it is not exhausted.
+func HostRateLimitReset(r string) time.Time {
+	repository, err := name.NewRepository(r)
+	if err != nil {
+		return time.Time{}
+	}
+
+	l := registryTransport.limiter(repository.RegistryStr())
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.blockedUntil.Before(time.Now()) {
+		return time.Time{}
+	}
+	return l.blockedUntil
+}
@@ -0,0 +1,118 @@
+package repo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterReserve(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := &hostLimiter{perMinute: 2}
+
+	if wait := l.reserve(now); wait != 0 {
+		t.Errorf("first request waited %s", wait)
+	}
+	if wait := l.reserve(now); wait != 0 {
+		t.Errorf("second request waited %s", wait)
+	}
+	if wait := l.reserve(now); wait != 30*time.Second {
+		t.Errorf("third request waited %s, want 30s", wait)
+	}
+
+	l.block(now.Add(time.Hour))
+	if wait := l.reserve(now.Add(time.Minute)); wait != 59*time.Minute {
+		t.Errorf("blocked request waited %s, want 59m", wait)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	header := http.Header{}
+	header.Set("RateLimit-Remaining", "0;w=21600")
+	header.Set("RateLimit-Reset", "120")
+
+	remaining, ok := rateLimitRemaining(header)
+	if !ok || remaining != 0 {
+		t.Errorf("got remaining %d (%t), want 0", remaining, ok)
+	}
+	if reset := rateLimitReset(header, now); !reset.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("got reset %s, want %s", reset, now.Add(2*time.Minute))
+	}
+	if reset := retryAfter(header, now); !reset.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("got retry after %s, want reset header fallback", reset)
+	}
+	header.Set("Retry-After", "30")
+	if reset := retryAfter(header, now); !reset.Equal(now.Add(30 * time.Second)) {
+		t.Errorf("got retry after %s, want %s", reset, now.Add(30*time.Second))
+	}
+	if _, ok := rateLimitRemaining(http.Header{}); ok {
+		t.Errorf("missing header must not be parsed")
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	rt := &rateLimitTransport{
+		base:    http.DefaultTransport,
+		hosts:   map[string]*hostLimiter{},
+		maxWait: time.Minute,
+	}
+	client := &http.Client{Transport: rt}
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Get(server.URL)
+		var rateLimitErr *RateLimitError
+		if !errors.As(err, &rateLimitErr) {
+			t.Fatalf("request %d : got error %v, want RateLimitError", i, err)
+		}
+		if time.Until(rateLimitErr.Reset) < 59*time.Minute {
+			t.Errorf("request %d : got reset %s, want in 1h", i, rateLimitErr.Reset)
+		}
+		if ClassifyError(err) != ErrorClassRateLimit {
+			t.Errorf("request %d : got class %s, want rate-limit", i, ClassifyError(err))
+		}
+	}
+	if hits != 1 {
+		t.Errorf("got %d requests sent, want 1 until the rate limit resets", hits)
+	}
+}
+
+func TestReset(t *testing.T) {
+	defer Reset()
+	r := "reset.example.com/team/app"
+	if err := SetHostRequestsPerMinute(r, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetHostBandwidthLimit(r, 1000); err != nil {
+		t.Fatal(err)
+	}
+	SetBandwidthLimit(1000)
+	registryTransport.limiter("reset.example.com").block(time.Now().Add(time.Hour))
+
+	Reset()
+	if err := SetHostRequestsPerMinute(r, 60); err != nil {
+		t.Fatal(err)
+	}
+	if perMinute := registryTransport.limiter("reset.example.com").perMinute; perMinute != 60 {
+		t.Errorf("got %d requests per minute after reset, want 60", perMinute)
+	}
+	if perSecond := bandwidth.limiter("reset.example.com").perSecond; perSecond != 0 {
+		t.Errorf("got host bandwidth limit %d after reset", perSecond)
+	}
+	if perSecond := bandwidth.global.perSecond; perSecond != 0 {
+		t.Errorf("got bandwidth limit %d after reset", perSecond)
+	}
+	if HostRateLimitReset(r).IsZero() {
+		t.Errorf("host rate limit pause must be kept")
+	}
+}
@@ -10,8 +10,8 @@ import (
 	"github.com/docker/cli/cli/config"
 	"github.com/docker/cli/cli/config/types"
 	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
 type loginOptions struct {
@@ -56,12 +56,24 @@ func login(opts loginOptions) error {
 // ListRepo return the complete list of all existing tags for
 // a given repository.
 func ListRepo(r string) ([]string, error) {
-	tags, err := crane.ListTags(r)
-	if err != nil {
-		err = fmt.Errorf("repo list tags : %w", err)
-	}
+	return sourceFor(r).ListTags(r)
+}
 
-	return tags, err
+// TagDigest returns the manifest digest of a tag.
+func TagDigest(tag string, r string) (string, error) {
+	return sourceFor(r).TagDigest(tag, r)
+}
+
+// Reset clears the limits, sources, destination, layer cache and pushed
+// blobs set for a previous sync, as the lowest limit set wins: a reloaded
+// config applies from scratch. Pauses until a host rate limit budget resets are kept.
+func Reset() {
+	registryTransport.resetLimits()
+	bandwidth.resetLimits()
+	sources = map[string]Source{}
+	destination = Registry{}
+	layerCache = nil
+	targetBlobs.reset()
 }
 
 // SetHostCredentials registers credentials for a given registry address.
@@ -99,15 +111,108 @@ func SetHostCredentials(repoAddress string, user string, pass string) error {
 	return err
 }
 
-// SyncTagBetweenRepos copies a single tag from a repo to another
-func SyncTagBetweenRepos(tag string, source string, target string) error {
-	src := source + ":" + tag
-	dst := target + ":" + tag
+// reference returns the reference of a tag in a repository.
+// A digest ("sha256:...") may be given instead of a tag.
+func reference(r string, tag string) string {
+	if strings.Contains(tag, ":") {
+		return r + "@" + tag
+	}
+	return r + ":" + tag
+}
 
-	err := crane.Copy(src, dst)
+func copyBetweenRepos(source string, sourceTag string, tag string, target string, platforms []string) error {
+	if len(platforms) > 0 {
+		parsedPlatforms, err := ParsePlatforms(platforms)
+		if err != nil {
+			return fmt.Errorf("repo copy tag : %w", err)
+		}
+		err = copyPlatforms(source, sourceTag, tag, target, parsedPlatforms)
+		if err != nil {
+			err = fmt.Errorf("repo copy tag : %w", err)
+		}
+		return err
+	}
+
+	err := copyManifest(source, sourceTag, tag, target)
 	if err != nil {
 		err = fmt.Errorf("repo copy tag : %w", err)
 	}
 
 	return err
 }
+
+// copyManifest copies a source tag to a target tag as-is, like crane.Copy.
+func copyManifest(source string, sourceTag string, tag string, target string) error {
+	manifest, _, err := getManifest(source, sourceTag)
+	if err != nil {
+		return err
+	}
+
+	return write(tag, target, manifest)
+}
+
+// SyncTagBetweenRepos copies a single tag from a repo to another.
+// When platforms are provided, only matching images are copied.
+func SyncTagBetweenRepos(tag string, source string, target string, platforms []string) error {
+	return copyBetweenRepos(source, tag, tag, target, platforms)
+}
+
+// SyncDigestBetweenRepos copies a source manifest digest to a target tag.
+// When platforms are provided, only matching images are copied.
+func SyncDigestBetweenRepos(digest string, tag string, source string, target string, platforms []string) error {
+	return copyBetweenRepos(source, digest, tag, target, platforms)
+}
+
+// TagSize returns the total size of a tag written to the destination:
+// manifests, configs and layers. Layers shared by several platforms of a
+// multi-arch tag are counted once.
+func TagSize(tag string, target string) (int64, error) {
+	manifest, desc, err := readTarget(target, tag)
+	if err != nil {
+		return 0, fmt.Errorf("repo tag size : %w", err)
+	}
+
+	size := desc.Size
+	blobs := map[v1.Hash]int64{}
+	addImage := func(img v1.Image) error {
+		manifest, err := img.Manifest()
+		if err != nil {
+			return err
+		}
+		blobs[manifest.Config.Digest] = manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			blobs[layer.Digest] = layer.Size
+		}
+		return nil
+	}
+
+	switch m := manifest.(type) {
+	case v1.ImageIndex:
+		indexManifest, err := m.IndexManifest()
+		if err != nil {
+			return 0, fmt.Errorf("repo tag size : %w", err)
+		}
+		for _, child := range indexManifest.Manifests {
+			size += child.Size
+			if !child.MediaType.IsImage() {
+				continue
+			}
+			img, err := m.Image(child.Digest)
+			if err != nil {
+				return 0, fmt.Errorf("repo tag size : %w", err)
+			}
+			if err := addImage(img); err != nil {
+				return 0, fmt.Errorf("repo tag size : %w", err)
+			}
+		}
+	case v1.Image:
+		if err := addImage(m); err != nil {
+			return 0, fmt.Errorf("repo tag size : %w", err)
+		}
+	}
+
+	for _, blobSize := range blobs {
+		size += blobSize
+	}
+	return size, nil
+}
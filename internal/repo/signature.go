@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+)
+
+// Signature is a cosign signature attached to an image.
+type Signature struct {
+	// Payload is the signed "simple signing" JSON document.
+	Payload []byte
+	// Base64Signature is the base64 encoded signature of Payload.
+	Base64Signature string
+	// Certificate and Chain are PEM encoded, only set by keyless signing.
+	Certificate []byte
+	Chain       []byte
+}
+
+// ListTagSignatures returns the digest of a source tag and the cosign
+// signatures attached to it. A tag without signature returns no error.
+// A digest may be given instead of a tag.
+func ListTagSignatures(tag string, source string) (string, []Signature, error) {
+	s := sourceFor(source)
+	_, desc, err := readManifest(s, source, tag)
+	if err != nil {
+		return "", nil, fmt.Errorf("repo list signatures : %w", err)
+	}
+
+	sigTag := artifactTag(desc.Digest) + ".sig"
+	if _, ok := s.(Registry); !ok {
+		tags, err := s.ListTags(source)
+		if err != nil {
+			return "", nil, fmt.Errorf("repo list signatures : %w", err)
+		}
+		if !stringInSlice(sigTag, tags) {
+			return desc.Digest.String(), []Signature{}, nil
+		}
+	}
+	sigManifest, _, err := readManifest(s, source, sigTag)
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return desc.Digest.String(), []Signature{}, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("repo list signatures : %w", err)
+	}
+	sigImg, ok := sigManifest.(v1.Image)
+	if !ok {
+		return "", nil, fmt.Errorf("repo list signatures : %s is not an image", reference(source, sigTag))
+	}
+
+	manifest, err := sigImg.Manifest()
+	if err != nil {
+		return "", nil, fmt.Errorf("repo list signatures : %w", err)
+	}
+
+	signatures := []Signature{}
+	for _, layer := range manifest.Layers {
+		sig, ok := layer.Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		l, err := sigImg.LayerByDigest(layer.Digest)
+		if err != nil {
+			return "", nil, fmt.Errorf("repo list signatures : %w", err)
+		}
+		rc, err := l.Compressed()
+		if err != nil {
+			return "", nil, fmt.Errorf("repo list signatures : %w", err)
+		}
+		payload, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", nil, fmt.Errorf("repo list signatures : %w", err)
+		}
+
+		signatures = append(signatures, Signature{
+			Payload:         payload,
+			Base64Signature: sig,
+			Certificate:     []byte(layer.Annotations[cosignCertificateAnnotation]),
+			Chain:           []byte(layer.Annotations[cosignChainAnnotation]),
+		})
+	}
+
+	return desc.Digest.String(), signatures, nil
+}
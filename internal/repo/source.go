@@ -0,0 +1,200 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Source is where images are read from. Repositories are source
+// repository addresses, and a digest may be given instead of a tag.
+type Source interface {
+	ListTags(r string) ([]string, error)
+	TagDigest(tag string, r string) (string, error)
+	// Manifest returns the image or index of a tag.
+	Manifest(tag string, r string) (remote.Taggable, error)
+}
+
+// sources maps repository names to the sources they are read from,
+// registries by default.
+var sources = map[string]Source{}
+
+// SetSource reads the repository address r from s instead of a registry.
+func SetSource(r string, s Source) error {
+	repository, err := name.NewRepository(r)
+	if err != nil {
+		return fmt.Errorf("repo set source : %w", err)
+	}
+	sources[repository.Name()] = s
+	return nil
+}
+
+func sourceFor(r string) Source {
+	repository, err := name.NewRepository(r)
+	if err != nil {
+		return Registry{}
+	}
+	if s, ok := sources[repository.Name()]; ok {
+		return s
+	}
+	return Registry{}
+}
+
+// getManifest reads a tag from the source of a repository, and returns
+// its image or index, other manifests as-is, with its descriptor.
+func getManifest(r string, tag string) (remote.Taggable, v1.Descriptor, error) {
+	return readManifest(sourceFor(r), r, tag)
+}
+
+// readManifest reads a tag from s, as getManifest does.
+func readManifest(s Source, r string, tag string) (remote.Taggable, v1.Descriptor, error) {
+	manifest, err := s.Manifest(tag, r)
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+	desc, err := describe(manifest)
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+	manifest, err = unwrapDescriptor(manifest)
+	return manifest, desc, err
+}
+
+// describe returns the descriptor of a manifest.
+func describe(manifest remote.Taggable) (v1.Descriptor, error) {
+	if d, ok := manifest.(*remote.Descriptor); ok {
+		return d.Descriptor, nil
+	}
+	raw, err := manifest.RawManifest()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	desc := v1.Descriptor{Size: int64(len(raw))}
+	desc.Digest, _, err = v1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	if m, ok := manifest.(interface {
+		MediaType() (types.MediaType, error)
+	}); ok {
+		desc.MediaType, err = m.MediaType()
+	}
+	return desc, err
+}
+
+// ListTags returns the tags of a repository.
+func (Registry) ListTags(r string) ([]string, error) {
+	tags, err := crane.ListTags(r, craneOptions()...)
+	if err != nil {
+		err = fmt.Errorf("repo list tags : %w", err)
+	}
+
+	return tags, err
+}
+
+// TagDigest returns the manifest digest of a tag.
+func (Registry) TagDigest(tag string, r string) (string, error) {
+	digest, err := crane.Digest(reference(r, tag), craneOptions()...)
+	if err != nil {
+		err = fmt.Errorf("repo tag digest : %w", err)
+	}
+
+	return digest, err
+}
+
+// Manifest fetches the manifest of a tag.
+func (Registry) Manifest(tag string, r string) (remote.Taggable, error) {
+	ref, err := name.ParseReference(reference(r, tag))
+	if err != nil {
+		return nil, err
+	}
+	return remote.Get(ref, remoteOptions()...)
+}
+
+// layoutTag returns the tag of a layout ref name in a repository. Bare
+// tags, as other tools name layout entries, belong to every repository.
+func layoutTag(refName string, r string) (string, bool) {
+	if strings.HasPrefix(refName, r+":") {
+		return strings.TrimPrefix(refName, r+":"), true
+	}
+	if refName != "" && !strings.ContainsAny(refName, ":/@") {
+		return refName, true
+	}
+	return "", false
+}
+
+// Manifest returns the image or index of a tag or digest of the layout.
+func (l *OCILayout) Manifest(tag string, r string) (remote.Taggable, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	idx, descs, err := l.index()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range descs {
+		if t, ok := layoutTag(desc.Annotations[AnnotationRefName], r); !(ok && t == tag) && desc.Digest.String() != tag {
+			continue
+		}
+		if desc.MediaType.IsIndex() {
+			return idx.ImageIndex(desc.Digest)
+		}
+		return idx.Image(desc.Digest)
+	}
+	return nil, fmt.Errorf("%s not found in %s", reference(r, tag), l.dir)
+}
+
+// Manifest returns the image of a tag or digest of the archive, recorded
+// or computed.
+func (a *DockerArchive) Manifest(tag string, r string) (remote.Taggable, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !strings.Contains(tag, ":") {
+		t, ok, err := a.find(tag, r)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s not found in %s", t, a.path)
+		}
+		return tarball.Image(a.opener(), &t)
+	}
+
+	tags, err := a.tags()
+	if err != nil {
+		return nil, err
+	}
+	digests, err := a.digests()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		t := t
+		if digests[t.Name()] == tag {
+			return tarball.Image(a.opener(), &t)
+		}
+	}
+	for _, t := range tags {
+		t := t
+		img, err := tarball.Image(a.opener(), &t)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if digest.String() == tag {
+			return img, nil
+		}
+	}
+	return nil, fmt.Errorf("%s not found in %s", reference(r, tag), a.path)
+}
@@ -0,0 +1,109 @@
+package repo
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+func TestSources(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	target := strings.TrimPrefix(server.URL, "http://") + "/mirror/app"
+
+	dir, err := ioutil.TempDir("", "imgsync-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A layout written by another tool, with a bare tag ref name.
+	layoutDir := filepath.Join(dir, "layout")
+	p, err := layout.Write(layoutDir, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AppendImage(img, layout.WithAnnotations(map[string]string{AnnotationRefName: "1.0.0"})); err != nil {
+		t.Fatal(err)
+	}
+
+	// A `docker save` of ci.local/app:1.0.0.
+	archive := filepath.Join(dir, "app.tar")
+	tag, err := name.NewTag("ci.local/app:1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tarball.WriteToFile(archive, tag, img); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		source Source
+	}{
+		{"oci", NewOCILayout(layoutDir)},
+		{"docker-archive", NewDockerArchive(archive)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := SetSource("ci.local/app", test.source); err != nil {
+				t.Fatal(err)
+			}
+			defer delete(sources, "ci.local/app")
+
+			tags, err := ListRepo("ci.local/app")
+			if err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			if len(tags) != 1 || tags[0] != "1.0.0" {
+				t.Fatalf("got tags %v, want 1.0.0", tags)
+			}
+			sourceDigest, err := TagDigest("1.0.0", "ci.local/app")
+			if err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			if sourceDigest != digest.String() {
+				t.Errorf("got digest %s, want %s", sourceDigest, digest)
+			}
+
+			signedDigest, signatures, err := ListTagSignatures("1.0.0", "ci.local/app")
+			if err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			if signedDigest != digest.String() || len(signatures) != 0 {
+				t.Errorf("got %s with %d signatures, want %s without", signedDigest, len(signatures), digest)
+			}
+
+			if err := SyncDigestBetweenRepos(sourceDigest, test.name, "ci.local/app", target, nil); err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			targetDigest, err := TagDigest(test.name, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if targetDigest != digest.String() {
+				t.Errorf("got target digest %s, want %s", targetDigest, digest)
+			}
+		})
+	}
+}
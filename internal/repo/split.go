@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// getIndex returns the index of a source tag, nil for an image.
+func getIndex(source string, tag string) (v1.ImageIndex, error) {
+	manifest, _, err := getManifest(source, tag)
+	if err != nil {
+		return nil, err
+	}
+	idx, _ := manifest.(v1.ImageIndex)
+	return idx, nil
+}
+
+// ListTagPlatforms returns the platforms ("os/arch[/variant]") provided by
+// a multi-arch tag, restricted to the given platforms when not empty.
+// Single-arch tags have no platform. A digest may be given instead of a tag.
+func ListTagPlatforms(tag string, source string, platforms []string) ([]string, error) {
+	parsedPlatforms, err := ParsePlatforms(platforms)
+	if err != nil {
+		return []string{}, fmt.Errorf("repo list platforms : %w", err)
+	}
+
+	idx, err := getIndex(source, tag)
+	if err != nil {
+		return []string{}, fmt.Errorf("repo list platforms : %w", err)
+	}
+	if idx == nil {
+		return []string{}, nil
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return []string{}, fmt.Errorf("repo list platforms : %w", err)
+	}
+
+	tagPlatforms := []string{}
+	for _, desc := range manifest.Manifests {
+		if desc.Platform == nil || !desc.MediaType.IsImage() {
+			continue
+		}
+		if len(parsedPlatforms) > 0 && !platformMatches(desc.Platform, parsedPlatforms) {
+			continue
+		}
+		tagPlatforms = append(tagPlatforms, desc.Platform.String())
+	}
+
+	return tagPlatforms, nil
+}
+
+// SplitTagBetweenRepos pushes each platform image of a multi-arch source
+// tag to the target under its own tag. platformTags maps a platform
+// ("os/arch[/variant]") to its target tag. A digest may be given
+// instead of a tag.
+func SplitTagBetweenRepos(tag string, source string, target string, platformTags map[string]string) error {
+	idx, err := getIndex(source, tag)
+	if err != nil {
+		return fmt.Errorf("repo split tag : %w", err)
+	}
+	if idx == nil {
+		return nil
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("repo split tag : %w", err)
+	}
+
+	for _, desc := range manifest.Manifests {
+		if desc.Platform == nil || !desc.MediaType.IsImage() {
+			continue
+		}
+		platformTag, ok := platformTags[desc.Platform.String()]
+		if !ok {
+			continue
+		}
+
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("repo split tag : %w", err)
+		}
+		if err := write(platformTag, target, img); err != nil {
+			return fmt.Errorf("repo split tag : %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package repo
+
+import "testing"
+
+func TestSplitTagBetweenRepos(t *testing.T) {
+	host := newTestRegistry(t)
+	source, target := host+"/app", host+"/mirror/app"
+
+	idx := platformIndex(t, "linux/amd64", "linux/arm64/v8", "linux/s390x")
+	pushTestManifest(t, source+":1.0", idx)
+	pushTestManifest(t, source+":2.0", platformImage(t, "linux/amd64"))
+
+	platforms, err := ListTagPlatforms("1.0", source, []string{"linux/amd64", "linux/arm64"})
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if len(platforms) != 2 || platforms[0] != "linux/amd64" || platforms[1] != "linux/arm64/v8" {
+		t.Errorf("got platforms %v, want linux/amd64 and linux/arm64/v8", platforms)
+	}
+
+	platformTags := map[string]string{"linux/amd64": "1.0-amd64", "linux/arm64/v8": "1.0-arm64v8"}
+	if err := SplitTagBetweenRepos("1.0", source, target, platformTags); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, desc := range manifest.Manifests {
+		tag, ok := platformTags[desc.Platform.String()]
+		if !ok {
+			continue
+		}
+		digest, err := TagDigest(tag, target)
+		if err != nil {
+			t.Fatalf("got %s not pushed : %v", tag, err)
+		}
+		if digest != desc.Digest.String() {
+			t.Errorf("got %s digest %s, want the %s image %s", tag, digest, desc.Platform, desc.Digest)
+		}
+	}
+	tags, err := Registry{}.ListTags(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 {
+		t.Errorf("got target tags %v, want the 2 platform tags only", tags)
+	}
+
+	// Single-arch tags are not split.
+	if err := SplitTagBetweenRepos("2.0", source, target, map[string]string{"linux/amd64": "2.0-amd64"}); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if platforms, err := ListTagPlatforms("2.0", source, nil); err != nil || len(platforms) != 0 {
+		t.Errorf("got platforms %v, %v for a single-arch tag", platforms, err)
+	}
+}
@@ -0,0 +1,33 @@
+package rewrite
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Replace rewrites the image values of a YAML document, Kubernetes
+// manifest or Compose file, from references to their replacement. The
+// rest of the document is kept byte for byte. It returns the number of
+// values replaced, and an error when a reference is not found as a plain
+// image value.
+func Replace(contents []byte, references map[string]string) ([]byte, int, error) {
+	replaced := 0
+	missing := []string{}
+	for reference, replacement := range references {
+		re := regexp.MustCompile(`(?m)^(\s*(?:-\s+)?image:\s*["']?)` + regexp.QuoteMeta(reference) + `(["']?\s*(?:#.*)?)$`)
+		n := len(re.FindAllIndex(contents, -1))
+		if n == 0 {
+			missing = append(missing, reference)
+			continue
+		}
+		contents = re.ReplaceAll(contents, []byte("${1}"+strings.ReplaceAll(replacement, "$", "$$")+"${2}"))
+		replaced += n
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, 0, fmt.Errorf("%s not found as image values", strings.Join(missing, ", "))
+	}
+	return contents, replaced, nil
+}
@@ -0,0 +1,227 @@
+// Package rewrite maps upstream image references to their mirrored
+// location in the target.
+package rewrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"gopkg.in/yaml.v2"
+)
+
+// Rewrite map formats.
+const (
+	FormatJSON           = "json"
+	FormatYAML           = "yaml"
+	FormatKustomize      = "kustomize"
+	FormatContainerd     = "hosts.toml"
+	FormatRegistriesConf = "registries.conf"
+)
+
+// Entry maps a source tag to its target tag and digest.
+type Entry struct {
+	Source string
+	Target string
+	Tag    string
+	Digest string
+}
+
+// SourceReference returns the source reference of an entry.
+func (e Entry) SourceReference() string {
+	return e.Source + ":" + e.Tag
+}
+
+// TargetReference returns the target reference of an entry, pinned to
+// its digest when known.
+func (e Entry) TargetReference() string {
+	if e.Digest == "" {
+		return e.Target + ":" + e.Tag
+	}
+	return e.Target + ":" + e.Tag + "@" + e.Digest
+}
+
+// Map is the rewrite map of every synced tag.
+type Map []Entry
+
+// FormatFromPath returns the format matching a file name, JSON by default.
+func FormatFromPath(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".yaml"), strings.HasSuffix(path, ".yml"):
+		return FormatYAML
+	case strings.HasSuffix(path, ".toml"):
+		return FormatContainerd
+	case strings.HasSuffix(path, ".conf"):
+		return FormatRegistriesConf
+	}
+	return FormatJSON
+}
+
+// Write writes the map in a format.
+func (m Map) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		return m.writeJSON(w)
+	case FormatYAML:
+		return m.writeYAML(w)
+	case FormatKustomize:
+		return m.writeKustomize(w)
+	case FormatContainerd:
+		return m.writeContainerdHosts(w)
+	case FormatRegistriesConf:
+		return m.writeRegistriesConf(w)
+	}
+	return fmt.Errorf("unknown rewrite map format \"%s\"", format)
+}
+
+func (m Map) references() map[string]string {
+	references := map[string]string{}
+	for _, entry := range m {
+		references[entry.SourceReference()] = entry.TargetReference()
+	}
+	return references
+}
+
+func (m Map) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m.references())
+}
+
+func (m Map) writeYAML(w io.Writer) error {
+	raw, err := yaml.Marshal(m.references())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(raw)
+	return err
+}
+
+// repositories returns the target repository of each source repository.
+func (m Map) repositories() ([]string, map[string]string) {
+	targets := map[string]string{}
+	sources := []string{}
+	for _, entry := range m {
+		if _, ok := targets[entry.Source]; !ok {
+			sources = append(sources, entry.Source)
+		}
+		targets[entry.Source] = entry.Target
+	}
+	sort.Strings(sources)
+	return sources, targets
+}
+
+// Familiar returns the short name of a repository, as written in
+// manifests ("nginx" for Docker Hub official images).
+func Familiar(repository string) string {
+	repository = strings.TrimPrefix(repository, name.DefaultRegistry+"/")
+	repository = strings.TrimPrefix(repository, "docker.io/")
+	return strings.TrimPrefix(repository, "library/")
+}
+
+// writeKustomize writes a kustomize images block, pinned to the digest
+// of repositories with a single tag.
+func (m Map) writeKustomize(w io.Writer) error {
+	type image struct {
+		Name    string `yaml:"name"`
+		NewName string `yaml:"newName"`
+		Digest  string `yaml:"digest,omitempty"`
+	}
+	digests := map[string][]string{}
+	for _, entry := range m {
+		digests[entry.Source] = append(digests[entry.Source], entry.Digest)
+	}
+
+	sources, targets := m.repositories()
+	images := []image{}
+	for _, source := range sources {
+		i := image{Name: Familiar(source), NewName: targets[source]}
+		if len(digests[source]) == 1 {
+			i.Digest = digests[source][0]
+		}
+		images = append(images, i)
+	}
+	raw, err := yaml.Marshal(map[string][]image{"images": images})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(raw)
+	return err
+}
+
+// writeContainerdHosts writes a hosts.toml per source registry, pointing
+// to the target path holding its repositories. containerd keeps the
+// repository path, which every target repository must end with.
+func (m Map) writeContainerdHosts(w io.Writer) error {
+	mirrors := map[string]string{}
+	hosts := []string{}
+	sources, targets := m.repositories()
+	for _, source := range sources {
+		repository, err := name.NewRepository(source)
+		if err != nil {
+			return err
+		}
+		host := repository.RegistryStr()
+		mirror := strings.TrimSuffix(targets[source], "/"+repository.RepositoryStr())
+		if mirror == targets[source] {
+			return fmt.Errorf("%s : target %s does not end with the repository path containerd pulls", source, targets[source])
+		}
+		if previous, ok := mirrors[host]; ok && previous != mirror {
+			return fmt.Errorf("%s : repositories are mirrored to both %s and %s", host, previous, mirror)
+		}
+		if _, ok := mirrors[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		mirrors[host] = mirror
+	}
+
+	for i, host := range hosts {
+		server, dir := "https://"+host, host
+		if host == name.DefaultRegistry {
+			server, dir = "https://registry-1.docker.io", "docker.io"
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		mirrorHost := mirrors[hosts[i]]
+		override := ""
+		if j := strings.Index(mirrorHost, "/"); j >= 0 {
+			mirrorHost = mirrorHost[:j] + "/v2" + mirrorHost[j:]
+			override = "  override_path = true\n"
+		}
+		_, err := fmt.Fprintf(w, "# /etc/containerd/certs.d/%s/hosts.toml\nserver = \"%s\"\n\n[host.\"https://%s\"]\n  capabilities = [\"pull\", \"resolve\"]\n%s",
+			dir, server, mirrorHost, override)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRegistriesConf writes a containers registries.conf mirror of each
+// source repository.
+func (m Map) writeRegistriesConf(w io.Writer) error {
+	sources, targets := m.repositories()
+	for i, source := range sources {
+		repository, err := name.NewRepository(source)
+		if err != nil {
+			return err
+		}
+		prefix := repository.Name()
+		if repository.RegistryStr() == name.DefaultRegistry {
+			prefix = "docker.io/" + repository.RepositoryStr()
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		_, err = fmt.Fprintf(w, "[[registry]]\nprefix = \"%s\"\nlocation = \"%s\"\n\n[[registry.mirror]]\nlocation = \"%s\"\n",
+			prefix, prefix, targets[source])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
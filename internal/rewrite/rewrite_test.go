@@ -0,0 +1,108 @@
+package rewrite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var testMap = Map{
+	{Source: "index.docker.io/library/nginx", Target: "mirror.local/hub/library/nginx", Tag: "1.25", Digest: "sha256:aaaa"},
+	{Source: "index.docker.io/library/nginx", Target: "mirror.local/hub/library/nginx", Tag: "1.24", Digest: "sha256:bbbb"},
+	{Source: "quay.io/prometheus/node-exporter", Target: "mirror.local/quay/prometheus/node-exporter", Tag: "v1.7.0", Digest: "sha256:cccc"},
+}
+
+func TestWrite(t *testing.T) {
+	var tests = []struct {
+		format string
+		want   []string
+	}{
+		{FormatJSON, []string{`"index.docker.io/library/nginx:1.25": "mirror.local/hub/library/nginx:1.25@sha256:aaaa"`}},
+		{FormatYAML, []string{`quay.io/prometheus/node-exporter:v1.7.0: mirror.local/quay/prometheus/node-exporter:v1.7.0@sha256:cccc`}},
+		{FormatKustomize, []string{"- name: nginx\n  newName: mirror.local/hub/library/nginx\n-", "digest: sha256:cccc"}},
+		{FormatContainerd, []string{
+			"# /etc/containerd/certs.d/docker.io/hosts.toml\nserver = \"https://registry-1.docker.io\"\n\n[host.\"https://mirror.local/v2/hub\"]",
+			"server = \"https://quay.io\"\n\n[host.\"https://mirror.local/v2/quay\"]",
+			"override_path = true",
+		}},
+		{FormatRegistriesConf, []string{"prefix = \"docker.io/library/nginx\"\nlocation = \"docker.io/library/nginx\"\n\n[[registry.mirror]]\nlocation = \"mirror.local/hub/library/nginx\""}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := testMap.Write(&buf, test.format); err != nil {
+				t.Fatalf("got unexpected error %v", err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("got %s, want it to contain %s", buf.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteContainerdHostsMismatch(t *testing.T) {
+	m := Map{{Source: "index.docker.io/library/nginx", Target: "mirror.local/nginx", Tag: "1.25"}}
+	if err := m.Write(&bytes.Buffer{}, FormatContainerd); err == nil {
+		t.Errorf("got no error for a target without the repository path")
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	var tests = map[string]string{
+		"map.json":        FormatJSON,
+		"map.yml":         FormatYAML,
+		"hosts.toml":      FormatContainerd,
+		"registries.conf": FormatRegistriesConf,
+		"map":             FormatJSON,
+	}
+	for path, want := range tests {
+		if ans := FormatFromPath(path); ans != want {
+			t.Errorf("%s : got %s, want %s", path, ans, want)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	contents := `spec:
+  containers:
+  - name: web
+    image: nginx:1.25 # pinned by rewrite
+  - image: "nginx:1.25"
+  - image: nginx:1.25-alpine
+services:
+  db:
+    image: 'postgres:16'
+`
+	replaced, n, err := Replace([]byte(contents), map[string]string{
+		"nginx:1.25":  "mirror.local/library/nginx:1.25@sha256:aaaa",
+		"postgres:16": "mirror.local/library/postgres:16",
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if n != 3 {
+		t.Errorf("got %d references replaced, want 3", n)
+	}
+	want := `spec:
+  containers:
+  - name: web
+    image: mirror.local/library/nginx:1.25@sha256:aaaa # pinned by rewrite
+  - image: "mirror.local/library/nginx:1.25@sha256:aaaa"
+  - image: nginx:1.25-alpine
+services:
+  db:
+    image: 'mirror.local/library/postgres:16'
+`
+	if string(replaced) != want {
+		t.Errorf("got\n%s\nwant\n%s", replaced, want)
+	}
+
+	// Flow mappings are parsed by discover but not replaced.
+	flow := "containers: [{name: web, image: nginx:1.25}]\n"
+	if _, _, err := Replace([]byte(flow), map[string]string{"nginx:1.25": "mirror.local/library/nginx:1.25"}); err == nil {
+		t.Errorf("got no error for a reference not replaced")
+	}
+}
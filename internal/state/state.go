@@ -0,0 +1,189 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TagState is what imgsync remembers about a tag synced to a target.
+type TagState struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Tag    string `json:"tag"`
+	// Digest is the source manifest digest of the last synced content,
+	// or the digest of the pushed index for assembled multi-arch tags.
+	Digest string `json:"digest"`
+	// SyncedAt and Size describe the last successful sync.
+	SyncedAt time.Time `json:"syncedAt"`
+	Size     int64     `json:"size,omitempty"`
+	// Outcome and Error describe the last sync attempt.
+	Outcome     string    `json:"outcome,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	AttemptedAt time.Time `json:"attemptedAt"`
+}
+
+// Store is a JSON file recording the state of synced tags between runs.
+type Store struct {
+	path  string
+	mu    sync.Mutex
+	tags  map[string]TagState
+	saved time.Time
+}
+
+type storeFile struct {
+	Tags []TagState `json:"tags"`
+}
+
+func key(source string, target string, tag string) string {
+	return source + "|" + target + "|" + tag
+}
+
+func sortTags(tags []TagState) {
+	sort.Slice(tags, func(i, j int) bool {
+		return key(tags[i].Source, tags[i].Target, tags[i].Tag) < key(tags[j].Source, tags[j].Target, tags[j].Tag)
+	})
+}
+
+// Load reads the state file at path. A missing file is an empty state.
+func Load(path string) (*Store, error) {
+	store := &Store{
+		path: path,
+		tags: map[string]TagState{},
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state : %w", err)
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("unmarshal state : %w", err)
+	}
+	for _, tag := range file.Tags {
+		store.tags[key(tag.Source, tag.Target, tag.Tag)] = tag
+	}
+
+	return store, nil
+}
+
+// Get returns the recorded state of a tag.
+func (s *Store) Get(source string, target string, tag string) (TagState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tagState, ok := s.tags[key(source, target, tag)]
+	return tagState, ok
+}
+
+// Set records the state of a tag.
+func (s *Store) Set(tagState TagState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tags[key(tagState.Source, tagState.Target, tagState.Tag)] = tagState
+}
+
+// Tags returns every recorded tag, sorted by source, target and tag.
+func (s *Store) Tags() []TagState {
+	s.mu.Lock()
+	tags := []TagState{}
+	for _, tag := range s.tags {
+		tags = append(tags, tag)
+	}
+	s.mu.Unlock()
+
+	sortTags(tags)
+	return tags
+}
+
+// Save atomically writes the state file.
+func (s *Store) Save() error {
+	file := storeFile{Tags: s.Tags()}
+	contents, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal state : %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".imgsync-state-")
+	if err != nil {
+		return fmt.Errorf("writing state : %w", err)
+	}
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing state : %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing state : %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("writing state : %w", err)
+	}
+
+	s.mu.Lock()
+	s.saved = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// SaveEvery saves the state file when it was last saved more than
+// interval ago, so long runs lose little on a crash without writing the
+// file for every tag.
+func (s *Store) SaveEvery(interval time.Duration) error {
+	s.mu.Lock()
+	due := time.Since(s.saved) >= interval
+	s.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+	return s.Save()
+}
+
+// Summary describes the recorded state of a source synced to a target.
+type Summary struct {
+	// Synced is the number of tags whose last successful sync is recorded.
+	Synced int
+	// LastSyncedAt is the time of the most recent successful tag sync.
+	LastSyncedAt time.Time
+	// Size is the total size of synced tags.
+	Size int64
+	// Failures are the tags whose last attempt did not succeed.
+	Failures []TagState
+}
+
+// Summarize returns the recorded state of a source synced to a target.
+// failedOutcomes lists the outcomes reported as failures.
+func (s *Store) Summarize(source string, target string, failedOutcomes []string) Summary {
+	summary := Summary{Failures: []TagState{}}
+	for _, tag := range s.Tags() {
+		if tag.Source != source || tag.Target != target {
+			continue
+		}
+		if !tag.SyncedAt.IsZero() {
+			summary.Synced++
+			summary.Size += tag.Size
+			if tag.SyncedAt.After(summary.LastSyncedAt) {
+				summary.LastSyncedAt = tag.SyncedAt
+			}
+		}
+		for _, outcome := range failedOutcomes {
+			if tag.Outcome == outcome {
+				summary.Failures = append(summary.Failures, tag)
+				break
+			}
+		}
+	}
+	return summary
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadMissingFile(t *testing.T) {
+	store, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if _, ok := store.Get("src", "dst", "1.0.0"); ok {
+		t.Errorf("empty state should not contain any tag")
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := TagState{Source: "index.docker.io/library/nginx", Target: "127.0.0.1:5000/nginx", Tag: "1.19.0", Digest: "sha256:abcd",
+		SyncedAt: time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC), Size: 1024, Outcome: "synced"}
+	store.Set(want)
+	store.Set(TagState{Source: "index.docker.io/library/nginx", Target: "127.0.0.1:5000/nginx", Tag: "1.18.0", Digest: "sha256:ef01"})
+	if err := store.Save(); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	got, ok := loaded.Get(want.Source, want.Target, want.Tag)
+	if !ok {
+		t.Fatalf("tag %s not found after reload", want.Tag)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got '%v', want '%v'", got, want)
+	}
+	if _, ok := loaded.Get(want.Source, "other", want.Tag); ok {
+		t.Errorf("tags must be recorded per target")
+	}
+}
+
+func TestSaveEvery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Set(TagState{Source: "index.docker.io/library/nginx", Target: "127.0.0.1:5000/nginx", Tag: "1.19.0"})
+	if err := store.SaveEvery(time.Hour); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	store.Set(TagState{Source: "index.docker.io/library/nginx", Target: "127.0.0.1:5000/nginx", Tag: "1.18.0"})
+	if err := store.SaveEvery(time.Hour); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := loaded.Tags(); len(tags) != 1 || tags[0].Tag != "1.19.0" {
+		t.Errorf("got saved tags %v, want only the first save", tags)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	store, err := Load(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	source, target := "index.docker.io/library/nginx", "127.0.0.1:5000/nginx"
+	store.Set(TagState{Source: source, Target: target, Tag: "1.18.0", SyncedAt: first, Size: 10, Outcome: "synced", AttemptedAt: first})
+	store.Set(TagState{Source: source, Target: target, Tag: "1.19.0", SyncedAt: last, Size: 20, Outcome: "failed", Error: "boom", AttemptedAt: last})
+	store.Set(TagState{Source: source, Target: target, Tag: "1.20.0", Outcome: "refused", Error: "unsigned", AttemptedAt: last})
+	store.Set(TagState{Source: source, Target: "other", Tag: "1.21.0", SyncedAt: last, Size: 40, Outcome: "synced", AttemptedAt: last})
+
+	summary := store.Summarize(source, target, []string{"failed", "refused"})
+	if summary.Synced != 2 || summary.Size != 30 {
+		t.Errorf("got %d tags of %d bytes, want 2 tags of 30 bytes", summary.Synced, summary.Size)
+	}
+	if !summary.LastSyncedAt.Equal(last) {
+		t.Errorf("got last sync at %s, want %s", summary.LastSyncedAt, last)
+	}
+	failures := []string{}
+	for _, failure := range summary.Failures {
+		failures = append(failures, failure.Tag)
+	}
+	if !reflect.DeepEqual(failures, []string{"1.19.0", "1.20.0"}) {
+		t.Errorf("got failures '%v', want 1.19.0 and 1.20.0", failures)
+	}
+}
@@ -0,0 +1,295 @@
+package verify
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/barthv/imgsync/internal/repo"
+)
+
+var (
+	// ErrUnsigned is returned when a tag has no signature at all.
+	ErrUnsigned = errors.New("no signature found")
+	// ErrBadSignature is returned when no signature of a tag is valid.
+	ErrBadSignature = errors.New("no valid signature found")
+
+	// Fulcio certificate extensions holding the OIDC issuer.
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// simpleSigning is the payload signed by cosign.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// Verifier checks cosign signatures of source tags.
+type Verifier struct {
+	publicKey      crypto.PublicKey
+	certIdentity   string
+	certOidcIssuer string
+	roots          *x509.CertPool
+	intermediates  *x509.CertPool
+}
+
+// New returns a Verifier from a source verify configuration.
+func New(conf config.Verify) (*Verifier, error) {
+	if conf.Key != "" {
+		keyPEM, err := ioutil.ReadFile(conf.Key)
+		if err != nil {
+			return nil, fmt.Errorf("verify key : %w", err)
+		}
+		publicKey, err := parsePublicKey(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("verify key : %w", err)
+		}
+		return &Verifier{publicKey: publicKey}, nil
+	}
+
+	if conf.CertIdentity == "" || conf.CertOidcIssuer == "" || conf.TrustRoot == "" {
+		return nil, fmt.Errorf("verify : key, or certIdentity, certOidcIssuer and trustRoot are required")
+	}
+
+	trustRootPEM, err := ioutil.ReadFile(conf.TrustRoot)
+	if err != nil {
+		return nil, fmt.Errorf("verify trust root : %w", err)
+	}
+	roots, intermediates, err := parseTrustRoot(trustRootPEM)
+	if err != nil {
+		return nil, fmt.Errorf("verify trust root : %w", err)
+	}
+
+	return &Verifier{
+		certIdentity:   conf.CertIdentity,
+		certOidcIssuer: conf.CertOidcIssuer,
+		roots:          roots,
+		intermediates:  intermediates,
+	}, nil
+}
+
+func parsePublicKey(keyPEM []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func parseCertificates(certsPEM []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, certsPEM = pem.Decode(certsPEM)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// parseTrustRoot splits a PEM bundle into self-signed roots and
+// intermediate certificates.
+func parseTrustRoot(trustRootPEM []byte) (*x509.CertPool, *x509.CertPool, error) {
+	certs, err := parseCertificates(trustRootPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(certs) == 0 {
+		return nil, nil, fmt.Errorf("no certificate found")
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs {
+		if cert.CheckSignatureFrom(cert) == nil {
+			roots.AddCert(cert)
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	return roots, intermediates, nil
+}
+
+func verifySignature(publicKey crypto.PublicKey, payload []byte, signature []byte) error {
+	digest := sha256.Sum256(payload)
+
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return fmt.Errorf("invalid ecdsa signature")
+		}
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, signature) {
+			return fmt.Errorf("invalid ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	return nil
+}
+
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV2) {
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV1) {
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+func certificateHasIdentity(cert *x509.Certificate, identity string) bool {
+	for _, email := range cert.EmailAddresses {
+		if email == identity {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyCertificate checks a keyless signing certificate against the
+// trust root and expected identity, and returns its public key.
+// Without a transparency log timestamp, the chain is validated at the
+// certificate issuance time. Neither the Rekor inclusion proof nor the
+// certificate SCT are checked: a certificate misissued for the identity,
+// or a signature made after the certificate expired, is accepted.
+func (v *Verifier) verifyCertificate(certPEM []byte, chainPEM []byte) (crypto.PublicKey, error) {
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no signing certificate")
+	}
+	cert := certs[0]
+
+	chain, err := parseCertificates(chainPEM)
+	if err != nil {
+		return nil, err
+	}
+	intermediates := v.intermediates.Clone()
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   cert.NotBefore,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !certificateHasIdentity(cert, v.certIdentity) {
+		return nil, fmt.Errorf("certificate identity does not match %s", v.certIdentity)
+	}
+	if issuer := certificateIssuer(cert); issuer != v.certOidcIssuer {
+		return nil, fmt.Errorf("certificate issuer %s does not match %s", issuer, v.certOidcIssuer)
+	}
+
+	return cert.PublicKey, nil
+}
+
+// verifyOne checks that a signature is valid and signs the given digest.
+func (v *Verifier) verifyOne(digest string, sig repo.Signature) error {
+	publicKey := v.publicKey
+	if publicKey == nil {
+		if len(sig.Certificate) == 0 {
+			return fmt.Errorf("keyless signature without certificate")
+		}
+		var err error
+		publicKey, err = v.verifyCertificate(sig.Certificate, sig.Chain)
+		if err != nil {
+			return fmt.Errorf("certificate : %w", err)
+		}
+	}
+
+	rawSignature, err := base64.StdEncoding.DecodeString(sig.Base64Signature)
+	if err != nil {
+		return fmt.Errorf("decoding signature : %w", err)
+	}
+	if err := verifySignature(publicKey, sig.Payload, rawSignature); err != nil {
+		return err
+	}
+
+	var payload simpleSigning
+	if err := json.Unmarshal(sig.Payload, &payload); err != nil {
+		return fmt.Errorf("decoding payload : %w", err)
+	}
+	if payload.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("payload signs %s instead of %s", payload.Critical.Image.DockerManifestDigest, digest)
+	}
+
+	return nil
+}
+
+// Verify returns nil if at least one of the signatures is valid for digest.
+func (v *Verifier) Verify(digest string, signatures []repo.Signature) error {
+	if len(signatures) == 0 {
+		return ErrUnsigned
+	}
+
+	errs := []error{}
+	for _, sig := range signatures {
+		err := v.verifyOne(digest, sig)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+
+	return fmt.Errorf("%w : %v", ErrBadSignature, errs)
+}
+
+// VerifyTag fetches the signatures of a source tag and verifies them.
+func (v *Verifier) VerifyTag(tag string, source string) error {
+	digest, signatures, err := repo.ListTagSignatures(tag, source)
+	if err != nil {
+		return err
+	}
+	if err := v.Verify(digest, signatures); err != nil {
+		return fmt.Errorf("verify %s:%s@%s : %w", source, tag, digest, err)
+	}
+	return nil
+}
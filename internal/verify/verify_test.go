@@ -0,0 +1,281 @@
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barthv/imgsync/internal/config"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	s := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	t.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://") + "/test/image"
+}
+
+func pushRandomImage(t *testing.T, repository string, tag string) v1.Hash {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(repository + ":" + tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+// pushSignature signs a simple signing payload for digest and attaches it
+// to the signed image as cosign does.
+func pushSignature(t *testing.T, repository string, signed v1.Hash, payloadDigest string, key *ecdsa.PrivateKey, certPEM []byte) {
+	t.Helper()
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"%s"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, repository, payloadDigest))
+	hash := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annotations := map[string]string{
+		"dev.cosignproject.cosign/signature": base64.StdEncoding.EncodeToString(sig),
+	}
+	if certPEM != nil {
+		annotations["dev.sigstore.cosign/certificate"] = string(certPEM)
+	}
+	layer := static.NewLayer(payload, types.MediaType("application/vnd.dev.cosign.simplesigning.v1+json"))
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: layer, Annotations: annotations})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := name.ParseReference(repository + ":" + signed.Algorithm + "-" + signed.Hex + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writePublicKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "PUBLIC KEY", der)
+}
+
+func TestVerifyTagWithKey(t *testing.T) {
+	repository := newTestRegistry(t)
+	key := newKey(t)
+	otherKey := newKey(t)
+
+	signed := pushRandomImage(t, repository, "signed")
+	pushSignature(t, repository, signed, signed.String(), key, nil)
+	pushRandomImage(t, repository, "unsigned")
+	otherSigned := pushRandomImage(t, repository, "other-key")
+	pushSignature(t, repository, otherSigned, otherSigned.String(), otherKey, nil)
+	wrongPayload := pushRandomImage(t, repository, "wrong-payload")
+	pushSignature(t, repository, wrongPayload, signed.String(), key, nil)
+
+	verifier, err := New(config.Verify{Key: writePublicKey(t, key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		tag     string
+		wantErr error
+	}{
+		{"signed", nil},
+		{"unsigned", ErrUnsigned},
+		{"other-key", ErrBadSignature},
+		{"wrong-payload", ErrBadSignature},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("VerifyTag %s", test.tag)
+		t.Run(testname, func(t *testing.T) {
+			err := verifier.VerifyTag(test.tag, repository)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got '%v', want '%v'", err, test.wantErr)
+			}
+		})
+	}
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	key := newKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "imgsync test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert: cert, key: key, der: der}
+}
+
+// issue returns a short-lived, Fulcio-like signing certificate.
+func (ca testCA) issue(t *testing.T, key *ecdsa.PrivateKey, email string, issuer string) []byte {
+	t.Helper()
+	issuerValue, err := asn1.Marshal(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		NotBefore:      time.Now().Add(-30 * time.Minute),
+		NotAfter:       time.Now().Add(-20 * time.Minute),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses: []string{email},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidIssuerV2, Value: issuerValue},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestVerifyTagKeyless(t *testing.T) {
+	repository := newTestRegistry(t)
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	key := newKey(t)
+	issuer := "https://accounts.example.com"
+	identity := "release@example.com"
+
+	var tests = []struct {
+		tag     string
+		certPEM []byte
+		wantErr error
+	}{
+		{"signed", ca.issue(t, key, identity, issuer), nil},
+		{"wrong-identity", ca.issue(t, key, "someone@example.com", issuer), ErrBadSignature},
+		{"wrong-issuer", ca.issue(t, key, identity, "https://other.example.com"), ErrBadSignature},
+		{"untrusted-root", otherCA.issue(t, key, identity, issuer), ErrBadSignature},
+		{"no-certificate", nil, ErrBadSignature},
+	}
+
+	verifier, err := New(config.Verify{
+		CertIdentity:   identity,
+		CertOidcIssuer: issuer,
+		TrustRoot:      writePEM(t, "CERTIFICATE", ca.der),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("VerifyTag %s", test.tag)
+		t.Run(testname, func(t *testing.T) {
+			digest := pushRandomImage(t, repository, test.tag)
+			pushSignature(t, repository, digest, digest.String(), key, test.certPEM)
+
+			err := verifier.VerifyTag(test.tag, repository)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got '%v', want '%v'", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		conf      config.Verify
+		wantError bool
+	}{
+		{config.Verify{}, true},
+		{config.Verify{Key: "/does/not/exist.pub"}, true},
+		{config.Verify{CertIdentity: "foo@example.com", CertOidcIssuer: "https://example.com"}, true},
+	}
+
+	for _, test := range tests {
+		testname := fmt.Sprintf("New %+v", test.conf)
+		t.Run(testname, func(t *testing.T) {
+			_, err := New(test.conf)
+			if err != nil && !test.wantError {
+				t.Errorf("got unexpected error %v", err)
+			}
+			if err == nil && test.wantError {
+				t.Errorf("Error is expected, func returned nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Package webhook parses the push notifications of registries.
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Registry notification formats.
+const (
+	DockerHub    = "dockerhub"
+	Harbor       = "harbor"
+	Distribution = "distribution"
+)
+
+// Push is a tag pushed to a repository, named with its registry host.
+type Push struct {
+	Repository string
+	Tag        string
+}
+
+// Parse returns the tags pushed according to a notification.
+// Notifications of other events are ignored.
+func Parse(format string, body []byte) ([]Push, error) {
+	var pushes []Push
+	var err error
+	switch format {
+	case DockerHub:
+		pushes, err = parseDockerHub(body)
+	case Harbor:
+		pushes, err = parseHarbor(body)
+	case Distribution:
+		pushes, err = parseDistribution(body)
+	default:
+		return nil, fmt.Errorf("unknown webhook format \"%s\"", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s : %w", format, err)
+	}
+	return pushes, nil
+}
+
+func parseDockerHub(body []byte) ([]Push, error) {
+	var notification struct {
+		PushData struct {
+			Tag string `json:"tag"`
+		} `json:"push_data"`
+		Repository struct {
+			RepoName string `json:"repo_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, err
+	}
+	if notification.Repository.RepoName == "" || notification.PushData.Tag == "" {
+		return nil, fmt.Errorf("repository and tag are required")
+	}
+	return []Push{{
+		Repository: name.DefaultRegistry + "/" + notification.Repository.RepoName,
+		Tag:        notification.PushData.Tag,
+	}}, nil
+}
+
+func parseHarbor(body []byte) ([]Push, error) {
+	var notification struct {
+		Type      string `json:"type"`
+		EventData struct {
+			Resources []struct {
+				Tag         string `json:"tag"`
+				ResourceURL string `json:"resource_url"`
+			} `json:"resources"`
+			Repository struct {
+				RepoFullName string `json:"repo_full_name"`
+			} `json:"repository"`
+		} `json:"event_data"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, err
+	}
+	if notification.Type != "PUSH_ARTIFACT" && notification.Type != "pushImage" {
+		return []Push{}, nil
+	}
+
+	pushes := []Push{}
+	for _, resource := range notification.EventData.Resources {
+		host := strings.SplitN(resource.ResourceURL, "/", 2)[0]
+		if resource.Tag == "" || host == "" {
+			continue
+		}
+		pushes = append(pushes, Push{
+			Repository: host + "/" + notification.EventData.Repository.RepoFullName,
+			Tag:        resource.Tag,
+		})
+	}
+	return pushes, nil
+}
+
+func parseDistribution(body []byte) ([]Push, error) {
+	var envelope struct {
+		Events []struct {
+			Action string `json:"action"`
+			Target struct {
+				Repository string `json:"repository"`
+				Tag        string `json:"tag"`
+			} `json:"target"`
+			Request struct {
+				Host string `json:"host"`
+			} `json:"request"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+
+	pushes := []Push{}
+	for _, event := range envelope.Events {
+		// Blob pushes and pushes by digest have no tag.
+		if event.Action != "push" || event.Target.Tag == "" || event.Request.Host == "" {
+			continue
+		}
+		pushes = append(pushes, Push{
+			Repository: event.Request.Host + "/" + event.Target.Repository,
+			Tag:        event.Target.Tag,
+		})
+	}
+	return pushes, nil
+}
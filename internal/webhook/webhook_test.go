@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		format  string
+		body    string
+		want    []Push
+		wantErr bool
+	}{
+		{
+			DockerHub,
+			`{"push_data": {"tag": "1.2.0", "pusher": "ci"}, "repository": {"repo_name": "team/app", "namespace": "team"}}`,
+			[]Push{{Repository: "index.docker.io/team/app", Tag: "1.2.0"}},
+			false,
+		},
+		{DockerHub, `{"repository": {"repo_name": "team/app"}}`, nil, true},
+		{
+			Harbor,
+			`{"type": "PUSH_ARTIFACT", "event_data": {"resources": [{"digest": "sha256:aaaa", "tag": "v1", "resource_url": "harbor.example.com/library/app:v1"}], "repository": {"name": "app", "namespace": "library", "repo_full_name": "library/app"}}}`,
+			[]Push{{Repository: "harbor.example.com/library/app", Tag: "v1"}},
+			false,
+		},
+		{Harbor, `{"type": "DELETE_ARTIFACT", "event_data": {}}`, []Push{}, false},
+		{
+			Distribution,
+			`{"events": [
+				{"action": "push", "target": {"mediaType": "application/octet-stream", "repository": "app", "digest": "sha256:bbbb"}, "request": {"host": "registry.example.com:5000"}},
+				{"action": "push", "target": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "repository": "team/app", "tag": "2.0"}, "request": {"host": "registry.example.com:5000"}},
+				{"action": "pull", "target": {"repository": "team/app", "tag": "2.0"}, "request": {"host": "registry.example.com:5000"}}
+			]}`,
+			[]Push{{Repository: "registry.example.com:5000/team/app", Tag: "2.0"}},
+			false,
+		},
+		{Distribution, `not json`, nil, true},
+		{"quay", `{}`, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			ans, err := Parse(test.format, []byte(test.body))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(ans, test.want) {
+				t.Errorf("got %+v, want %+v", ans, test.want)
+			}
+		})
+	}
+}